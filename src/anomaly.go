@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Anomaly is a snapshot which changed much more than usual
+type Anomaly struct {
+	Step        int     `json:"step"`
+	Type        string  `json:"type"`
+	Description string  `json:"description"`
+	Name        string  `json:"name"`   // path or stdin filename
+	Metric      string  `json:"metric"` // files_changed or data_added
+	Value       float64 `json:"value"`
+	Baseline    float64 `json:"baseline"`
+}
+
+// anomalyDetector compares each snapshot to the median of the previous
+// snapshots with the same path. A spike of changed files is typical for
+// ransomware encrypting the data.
+type anomalyDetector struct {
+	window      int     // snapshots in the baseline
+	minRuns     int     // snapshots required before anomalies are reported
+	filesFactor float64 // 0 disables the check
+	bytesFactor float64 // 0 disables the check
+	minFiles    int64   // changes below are never anomalies
+	minBytes    int64
+
+	mu      sync.Mutex
+	history map[string][]ResticSummary
+}
+
+func NewAnomalyDetector(window int, filesFactor float64, bytesFactor float64) *anomalyDetector {
+	d := &anomalyDetector{}
+	d.window = window
+	d.minRuns = 3
+	d.filesFactor = filesFactor
+	d.bytesFactor = bytesFactor
+	d.minFiles = 100
+	d.minBytes = 100 * 1024 * 1024
+	d.history = map[string][]ResticSummary{}
+
+	return d
+}
+
+// SetMinimum sets the changes which are always normal, regardless of the baseline
+func (d *anomalyDetector) SetMinimum(files int64, bytes int64) {
+	d.minFiles = files
+	d.minBytes = bytes
+}
+
+// Check compares the snapshots of a run to the baseline and adds them to it afterwards
+func (d *anomalyDetector) Check(r *RunReport) []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var anomalies []Anomaly
+	for _, s := range r.Steps {
+		for _, summary := range s.Snapshots {
+			history, ok := d.history[summary.Name]
+			if !ok {
+				history = d.load(r, summary)
+			}
+
+			if len(history) >= d.minRuns {
+				files := median(history, func(s ResticSummary) float64 { return float64(s.FilesNew + s.FilesChanged) })
+				bytes := median(history, func(s ResticSummary) float64 { return float64(s.DataAdded) })
+				a := Anomaly{Step: s.Index, Type: s.Type, Description: s.Description, Name: summary.Name}
+				changed := summary.FilesNew + summary.FilesChanged
+				if d.filesFactor > 0 && changed >= d.minFiles && float64(changed) > files*d.filesFactor {
+					a.Metric, a.Value, a.Baseline = "files_changed", float64(changed), files
+					anomalies = append(anomalies, a)
+				}
+				if d.bytesFactor > 0 && summary.DataAdded >= d.minBytes && float64(summary.DataAdded) > bytes*d.bytesFactor {
+					a.Metric, a.Value, a.Baseline = "data_added", float64(summary.DataAdded), bytes
+					anomalies = append(anomalies, a)
+				}
+			}
+
+			history = append(history, summary)
+			if len(history) > d.window {
+				history = history[len(history)-d.window:]
+			}
+			d.history[summary.Name] = history
+		}
+	}
+
+	for _, a := range anomalies {
+		logger.Warn("anomaly detected", zap.Int("index", a.Step), zap.String("type", a.Type), zap.String("name", a.Name),
+			zap.String("metric", a.Metric), zap.Float64("value", a.Value), zap.Float64("baseline", a.Baseline),
+		)
+	}
+
+	return anomalies
+}
+
+// load initializes the baseline from the summaries restic stores in snapshots since 0.17
+func (d *anomalyDetector) load(r *RunReport, summary ResticSummary) []ResticSummary {
+	snapshots, err := listSnapshots(r.snapshotFilters(summary.Name)...)
+	if err != nil {
+		logger.Warn("failed to load anomaly baseline", zap.String("name", summary.Name), zap.Error(err))
+		return nil
+	}
+
+	var history []ResticSummary
+	for _, s := range snapshots {
+		if s.Summary == nil || (summary.SnapshotId != "" && strings.HasPrefix(s.Id, summary.SnapshotId)) {
+			continue
+		}
+		history = append(history, *s.Summary)
+	}
+	if len(history) > d.window {
+		history = history[len(history)-d.window:]
+	}
+	logger.Debug("anomaly baseline loaded", zap.String("name", summary.Name), zap.Int("snapshots", len(history)))
+
+	return history
+}
+
+func median(summaries []ResticSummary, value func(ResticSummary) float64) float64 {
+	values := make([]float64, 0, len(summaries))
+	for _, s := range summaries {
+		values = append(values, value(s))
+	}
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+
+	return (values[n/2-1] + values[n/2]) / 2
+}
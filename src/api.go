@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// apiHandler serves the json api below /api/v1/, it backs the dashboard
+type apiHandler struct {
+	set      *BackupSet
+	stats    *repositoryStats // nil if statistics are disabled
+	history  *runHistory
+	progress *broker
+	events   *broker
+}
+
+func (a *apiHandler) register() {
+	http.HandleFunc("/api/v1/status", a.get(func(r *http.Request) (interface{}, error) {
+		return a.set.Status(), nil
+	}))
+	http.HandleFunc("/api/v1/history", a.get(func(r *http.Request) (interface{}, error) {
+		return a.history.Reports(), nil
+	}))
+	http.HandleFunc("/api/v1/stats", a.get(func(r *http.Request) (interface{}, error) {
+		return a.stats.Last(), nil
+	}))
+	http.HandleFunc("/api/v1/snapshots", a.get(func(r *http.Request) (interface{}, error) {
+		var filters []string
+		for _, name := range []string{"host", "path", "tag"} {
+			for _, value := range r.URL.Query()[name] {
+				filters = append(filters, "--"+name+"="+value)
+			}
+		}
+		if r.URL.Query().Get("step") == "" {
+			return listSnapshots(filters...)
+		}
+		index, err := strconv.Atoi(r.URL.Query().Get("step"))
+		if err != nil {
+			return nil, errors.New("invalid step index")
+		}
+		return a.set.Snapshots(index, filters...)
+	}))
+	http.HandleFunc("/api/v1/ls", a.get(func(r *http.Request) (interface{}, error) {
+		dir := r.URL.Query().Get("path")
+		if dir == "" {
+			dir = "/"
+		}
+		return listNodes(r.URL.Query().Get("snapshot"), dir)
+	}))
+	http.HandleFunc("/api/v1/dump", a.dump)
+	http.HandleFunc("/api/v1/diff", a.get(func(r *http.Request) (interface{}, error) {
+		from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		if from != "" || to != "" {
+			root := r.URL.Query().Get("root")
+			if root == "" {
+				root = "/"
+			}
+			return diffSnapshots(from, to, root)
+		}
+		index, err := strconv.Atoi(r.URL.Query().Get("step"))
+		if err != nil {
+			return nil, errors.New("invalid step index")
+		}
+		return a.set.Diff(index)
+	}))
+	http.HandleFunc("/api/v1/start", a.post(func(r *http.Request) (interface{}, error) {
+		return nil, a.set.Start()
+	}))
+	http.HandleFunc("/api/v1/check", a.post(func(r *http.Request) (interface{}, error) {
+		return nil, a.set.StartCheck()
+	}))
+	http.HandleFunc("/api/v1/restore", a.post(func(r *http.Request) (interface{}, error) {
+		index, err := strconv.Atoi(r.URL.Query().Get("step"))
+		if err != nil {
+			return nil, errors.New("invalid step index")
+		}
+		snapshot := r.URL.Query().Get("snapshot")
+		if snapshot == "" {
+			snapshot = "latest"
+		}
+		return nil, a.set.Restore(index, snapshot)
+	}))
+	http.Handle("/api/v1/progress", a.progress)
+	http.Handle("/api/v1/events", a.events)
+}
+
+// get wraps a read-only handler
+func (a *apiHandler) get(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		a.serve(w, r, fn)
+	}
+}
+
+// post wraps an action, POST only so links and crawlers do not trigger it
+func (a *apiHandler) post(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		a.serve(w, r, fn)
+	}
+}
+
+func (a *apiHandler) serve(w http.ResponseWriter, r *http.Request, fn func(r *http.Request) (interface{}, error)) {
+	v, err := fn(r)
+	if err != nil {
+		logger.Debug("api request failed", zap.String("path", r.URL.Path), zap.Error(err))
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if v == nil && r.Method == http.MethodPost {
+		v = map[string]string{"status": "ok"}
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// dump downloads a file, or a directory as tar or zip archive
+func (a *apiHandler) dump(w http.ResponseWriter, r *http.Request) {
+	snapshot := r.URL.Query().Get("snapshot")
+	p := r.URL.Query().Get("path")
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		archive = "tar"
+	}
+	if err := validateSnapshotPath(snapshot, p); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	node, err := statNode(snapshot, p)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if node == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "path not found in snapshot"})
+		return
+	}
+
+	filename := node.Name
+	contentType := "application/octet-stream"
+	if node.Type == "dir" {
+		if !isDumpArchive(archive) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown archive format: " + archive})
+			return
+		}
+		if filename == "/" {
+			filename = "snapshot-" + snapshot
+		}
+		filename += "." + archive
+		contentType = "application/x-tar"
+		if archive == "zip" {
+			contentType = "application/zip"
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	// The status is sent with the first bytes, errors are logged only.
+	// The request context ends the dump if the client disconnects.
+	logger.Info("dumping snapshot path", zap.String("snapshot", snapshot), zap.String("path", node.Path))
+	dumpNode(r.Context(), w, snapshot, node, archive)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"errors"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -12,10 +15,29 @@ import (
 type BackupSet struct {
 	destination BackupDestination
 	running     safeBool
-	waitGroup   sync.WaitGroup
+	deferred    safeBool
+	maxParallel int
 	steps       []BackupStep
+	discovery   StepDiscovery
+	name        string
 
-	metrics *MetricsCollection
+	metrics   *MetricsCollection
+	notifiers []Notifier
+	progress  *broker
+	events    *broker
+
+	blackout            []timeWindow
+	anomalies           *anomalyDetector
+	anomalyBlocksForget bool
+
+	// steps of the current or last run, including discovered steps
+	mu        sync.Mutex
+	runSteps  []BackupStep
+	started   time.Time
+	lastCheck *CheckResult
+
+	// hooks around the whole set
+	hookable
 }
 
 // BackupDestination contains infos about the restic repository to use.
@@ -24,6 +46,19 @@ type BackupDestination struct {
 	repository string // currently not used, see environment variable RESTIC_REPOSITORY
 	password   string // currently not used, see environment variable RESTIC_PASSWORD
 	hostname   string // used as --host argument
+	tag        string // tag of the backup set, used as --tag filter
+	limits     *bandwidthLimits
+}
+
+// setTagArgs selects the snapshots of the backup set, restic matches
+// untagged snapshots of earlier versions by the empty tag
+func (d BackupDestination) setTagArgs() []string {
+	return []string{"--tag", d.tag, "--tag", ""}
+}
+
+// limitArgs returns the bandwidth limits for a restic command started now
+func (d BackupDestination) limitArgs() []string {
+	return d.limits.args(time.Now())
 }
 
 // BackupStep is the basic interface for all steps, volumes, databases, ...
@@ -35,6 +70,17 @@ type BackupStep interface {
 	SetDestination(BackupDestination)
 }
 
+// StepDiscovery creates additional steps at the beginning of each run
+type StepDiscovery interface {
+	Discover() ([]BackupStep, error)
+}
+
+// RestorableStep is implemented by steps which can restore their own snapshots
+type RestorableStep interface {
+	BackupStep
+	Restore(snapshot string) error
+}
+
 func (b *BackupSet) SetRepository(repository string, password string) {
 	logger.Debug("set repository", zap.String("repository", repository), zap.Int("passwordLength", len(password)))
 	b.destination.repository = repository
@@ -45,17 +91,88 @@ func (b *BackupSet) SetHostname(hostname string) {
 	logger.Debug("set hostname", zap.String("hostname", hostname))
 	b.destination.hostname = hostname
 }
+func (b *BackupSet) SetName(name string) {
+	logger.Debug("set name", zap.String("name", name))
+	b.name = name
+	b.destination.tag = setTag(name)
+}
+
+// setTag is the tag of the snapshots of a backup set
+func setTag(name string) string {
+	return "set:" + name
+}
+
+func (b *BackupSet) AddNotifier(n Notifier) {
+	logger.Info("add notifier", zap.String("notifier", n.Name()))
+	b.notifiers = append(b.notifiers, n)
+}
+
 func (b *BackupSet) SetMetrics(m *MetricsCollection) {
 	logger.Debug("assign metrics collection")
 	b.metrics = m
 }
 
+// SetBandwidthLimits limits restic, it must be called before steps are added
+func (b *BackupSet) SetBandwidthLimits(l *bandwidthLimits) {
+	b.destination.limits = l
+	resticLimits = l
+}
+
+// SetBlackoutWindows defers scheduled runs within these windows until they end
+func (b *BackupSet) SetBlackoutWindows(windows []timeWindow) {
+	b.blackout = windows
+}
+
+// waitForBlackout blocks until no blackout window is active. Only one run
+// waits, further runs are dropped meanwhile and false is returned.
+func (b *BackupSet) waitForBlackout() bool {
+	until, err := windowsEnd(b.blackout, time.Now())
+	if err != nil {
+		logger.Error("backup skipped, no end of blackout", zap.Error(err))
+		return false
+	}
+	if !until.After(time.Now()) {
+		return true
+	}
+	if !b.deferred.SetIf(true, false) {
+		logger.Info("backup already deferred, skipped")
+		return false
+	}
+	defer b.deferred.Set(false)
+
+	logger.Info("backup deferred by blackout window", zap.Time("until", until))
+	b.publish(EventRunDeferred, map[string]time.Time{"until": until})
+	time.Sleep(time.Until(until))
+
+	return true
+}
+
+// SetAnomalyDetector checks the snapshots of each run for unusual changes
+func (b *BackupSet) SetAnomalyDetector(d *anomalyDetector) {
+	b.anomalies = d
+}
+
+// SetAnomalyBlocksForget marks runs with anomalies, so a forget in the post hook can keep the good snapshots
+func (b *BackupSet) SetAnomalyBlocksForget(block bool) {
+	b.anomalyBlocksForget = block
+}
+
+// SetProgressBroker publishes the progress of running steps
+func (b *BackupSet) SetProgressBroker(br *broker) {
+	b.progress = br
+}
+
 func (b *BackupSet) AddStep(s BackupStep) {
 	logger.Info("add backup step", zap.String("type", s.Type()), zap.String("description", s.Description()))
 	s.SetDestination(b.destination)
 	b.steps = append(b.steps, s)
 }
 
+func (b *BackupSet) SetDiscovery(d StepDiscovery) {
+	logger.Debug("assign step discovery")
+	b.discovery = d
+}
+
 func (b *BackupSet) IsRunning() bool {
 	return b.running.Get()
 }
@@ -63,70 +180,397 @@ func (b *BackupSet) IsRunning() bool {
 // Start backup process and return not before finished
 // This prototype important for cron scheduler
 func (b *BackupSet) Run() {
+	b.RunBy(TriggerSchedule)
+}
+
+// RunBy is Run with the trigger source, it is tagged to the snapshots
+func (b *BackupSet) RunBy(trigger string) {
+	if trigger == TriggerSchedule || trigger == TriggerStartup {
+		if !b.waitForBlackout() {
+			return
+		}
+	}
 	if !b.running.SetIf(true, false) {
 		logger.Warn("backup already running")
 
 		return
 	}
-	defer b.running.Set(false)
 
-	b.run()
+	b.finish(b.run(trigger))
 }
 
 // Start backup process as goroutine and return immediately
 func (b *BackupSet) Start() error {
+	return b.StartBy(TriggerApi)
+}
+
+// StartBy is Start with the trigger source, it is tagged to the snapshots
+func (b *BackupSet) StartBy(trigger string) error {
 	if !b.running.SetIf(true, false) {
 		logger.Warn("backup already running")
 
 		return errors.New("Backup already running")
 	}
 	go func() {
-		defer b.running.Set(false)
-
-		b.run()
+		b.finish(b.run(trigger))
 	}()
 
 	return nil
 }
 
-// Internal method to run backup steps
+// finish releases the 'running' property before the notifiers are called,
+// so slow notifiers with retries do not drop the next scheduled run. Notifiers
+// which track the run itself (StartNotifier) get the result before, otherwise
+// the start of the next run could overtake the end of this one.
+func (b *BackupSet) finish(report *RunReport) {
+	if report == nil {
+		b.running.Set(false)
+		return
+	}
+
+	var tracking, others []Notifier
+	for _, n := range b.notifiers {
+		if _, ok := n.(StartNotifier); ok {
+			tracking = append(tracking, n)
+		} else {
+			others = append(others, n)
+		}
+	}
+	notify(tracking, report)
+	b.running.Set(false)
+	notify(others, report)
+}
+
+// Internal method to run backup steps, the report is nil if nothing ran
 // Can be executed via Run() or Start(), which handle the 'running' property
-func (b *BackupSet) run() {
+func (b *BackupSet) run(trigger string) (report *RunReport) {
 	logger.Info("starting backup set", zap.Int("step_count", len(b.steps)))
-	b.waitGroup = sync.WaitGroup{}
 
 	if b.metrics == nil {
 		logger.Error("metrics collection not assigned")
 		return
 	}
 
+	notifyStart(b.notifiers)
+	report = &RunReport{Id: newRunId(), Set: b.name, Host: b.destination.hostname, Trigger: trigger, Status: "success", Started: time.Now()}
+	b.publish(EventRunStarted, report)
+	defer func() {
+		report.Finished = time.Now()
+		b.publish(EventRunFinished, report)
+	}()
+
 	err := b.InitializeRepository()
 	if err != nil {
 		// log output in subroutine
+		report.Status = "failed"
+		report.Error = err.Error()
 		return
 	}
 
-	for i, s := range b.steps {
-		b.waitGroup.Add(1)
-		go func(i int, s BackupStep) {
-			defer b.waitGroup.Done()
+	// A failing pre hook skips the backup, the post hook runs anyway
+	err = b.hooks.Pre.Run(map[string]string{"RESTIC_AGENT_HOOK": "pre"})
+	if err != nil {
+		report.Status = "skipped"
+		report.Error = err.Error()
+		b.hooks.Post.Run(map[string]string{"RESTIC_AGENT_HOOK": "post", "RESTIC_AGENT_STATUS": "skipped", "RESTIC_AGENT_ERROR": err.Error()})
+		return
+	}
 
-			logger.Info("running backup step", zap.Int("index", i), zap.String("type", s.Type()), zap.String("description", s.Description()))
+	// copy, discovered steps are only valid for this run
+	steps := append([]BackupStep{}, b.steps...)
+	if b.discovery != nil {
+		discovered, err := b.discovery.Discover()
+		if err != nil {
+			// Run the static steps anyway
+			logger.Error("step discovery failed", zap.Error(err))
+			report.Error = "step discovery failed: " + err.Error()
+		}
+		for _, s := range discovered {
+			logger.Info("discovered backup step", zap.String("type", s.Type()), zap.String("description", s.Description()))
+			s.SetDestination(b.destination)
+			steps = append(steps, s)
+		}
+	}
 
-			err := s.Run(b.metrics)
-			b.metrics.BackupsTotal.Inc()
-			if err != nil {
-				b.metrics.BackupsFailed.Inc()
-				logger.Error("backup step failed", zap.Int("index", i), zap.String("type", s.Type()), zap.String("description", s.Description()), zap.Error(err))
-				return
-			}
-			b.metrics.BackupsSuccessful.Inc()
-			logger.Info("backup step finished", zap.Int("index", i), zap.String("type", s.Type()), zap.String("description", s.Description()))
-		}(i, s)
+	b.mu.Lock()
+	b.runSteps = steps
+	b.started = report.Started
+	b.mu.Unlock()
+	b.metrics.ResetProgress()
+	for i, s := range steps {
+		if ps, ok := s.(ProgressStep); ok {
+			ps.SetProgressHandler(b.progressHandler(i, s))
+		}
+		if ts, ok := s.(TaggedStep); ok {
+			ts.SetRunTags([]string{b.destination.tag, "type:" + s.Type(), "trigger:" + trigger, "run:" + report.Id})
+		}
+	}
+
+	// Each goroutine writes its own element only
+	report.Steps = make([]StepReport, len(steps))
+	blocked := b.scheduleSteps(steps, func(i int) {
+		s := steps[i]
+		logger.Info("running backup step", zap.Int("index", i), zap.String("type", s.Type()), zap.String("description", s.Description()))
+		b.publish(EventStepStarted, StepStatus{Index: i, Type: s.Type(), Description: s.Description(), Running: true})
+
+		report.Steps[i] = b.runStep(i, s)
+		b.publish(EventStepFinished, report.Steps[i])
+		b.metrics.BackupsTotal.Inc()
+		if report.Steps[i].Status != "success" {
+			b.metrics.BackupsFailed.Inc()
+			logger.Error("backup step failed", zap.Int("index", i), zap.String("type", s.Type()), zap.String("description", s.Description()), zap.String("error", report.Steps[i].Error))
+			return
+		}
+		b.metrics.BackupsSuccessful.Inc()
+		logger.Info("backup step finished", zap.Int("index", i), zap.String("type", s.Type()), zap.String("description", s.Description()))
+	})
+	for i, reason := range blocked {
+		s := steps[i]
+		report.Steps[i] = StepReport{Index: i, Type: s.Type(), Description: s.Description(), Status: "skipped", Error: reason}
+		b.metrics.BackupsTotal.Inc()
+		b.metrics.BackupsFailed.Inc()
+		logger.Error("backup step skipped", zap.Int("index", i), zap.String("type", s.Type()), zap.String("description", s.Description()), zap.String("error", reason))
 	}
 
-	b.waitGroup.Wait()
 	logger.Info("all backup steps finished")
+
+	if report.FailedSteps() > 0 || report.Error != "" {
+		report.Status = "failed"
+	}
+
+	b.metrics.Anomalies.Reset()
+	if b.anomalies != nil {
+		report.Anomalies = b.anomalies.Check(report)
+		for _, a := range report.Anomalies {
+			b.metrics.Anomalies.WithLabelValues(strconv.Itoa(a.Step), a.Type, a.Description, a.Metric).Set(1)
+			b.metrics.AnomaliesTotal.Inc()
+		}
+	}
+
+	// Old snapshots are kept if something is wrong with the new ones
+	if len(report.Anomalies) > 0 && b.anomalyBlocksForget {
+		logger.Warn("forget blocked because of anomalies")
+		report.ForgetBlocked = true
+	}
+
+	env := map[string]string{
+		"RESTIC_AGENT_HOOK":           "post",
+		"RESTIC_AGENT_STATUS":         report.Status,
+		"RESTIC_AGENT_STEP_COUNT":     strconv.Itoa(len(steps)),
+		"RESTIC_AGENT_FAILED_COUNT":   strconv.Itoa(report.FailedSteps()),
+		"RESTIC_AGENT_ANOMALY_COUNT":  strconv.Itoa(len(report.Anomalies)),
+		"RESTIC_AGENT_FORGET_BLOCKED": strconv.FormatBool(report.ForgetBlocked),
+	}
+	b.hooks.Post.Run(env)
+
+	return
+}
+
+// progressHandler completes the progress of step i and publishes it
+func (b *BackupSet) progressHandler(i int, s BackupStep) func(StepProgress) {
+	return func(p StepProgress) {
+		p.Index = i
+		p.Type = s.Type()
+		p.Description = s.Description()
+		b.metrics.SetProgress(p)
+		b.progress.Publish(p)
+		b.publish(EventProgress, p)
+	}
+}
+
+// SetStatus is the state of the backup set returned by the status api
+type SetStatus struct {
+	Set     string       `json:"set"`
+	Host    string       `json:"host"`
+	Running bool         `json:"running"`
+	Started *time.Time   `json:"started,omitempty"` // current or last run
+	Steps   []StepStatus `json:"steps"`
+	Check   *CheckResult `json:"check,omitempty"`
+}
+
+type StepStatus struct {
+	Index       int           `json:"index"`
+	Type        string        `json:"type"`
+	Description string        `json:"description"`
+	Running     bool          `json:"running"`
+	Restorable  bool          `json:"restorable"`
+	Progress    *StepProgress `json:"progress,omitempty"`
+}
+
+// Status returns the steps of the current or last run with their progress
+func (b *BackupSet) Status() SetStatus {
+	b.mu.Lock()
+	steps := b.runSteps
+	started := b.started
+	b.mu.Unlock()
+
+	status := SetStatus{Set: b.name, Host: b.destination.hostname, Running: b.IsRunning(), Steps: []StepStatus{}, Check: b.LastCheck()}
+	if steps == nil {
+		steps = b.steps
+	} else {
+		status.Started = &started
+	}
+	for i, s := range steps {
+		st := StepStatus{Index: i, Type: s.Type(), Description: s.Description(), Running: s.IsRunning()}
+		// Restore supports the configured steps only
+		if _, ok := s.(RestorableStep); ok && i < len(b.steps) {
+			st.Restorable = true
+		}
+		if ps, ok := s.(ProgressStep); ok {
+			st.Progress = ps.Progress()
+			if st.Progress != nil {
+				st.Progress.Index = i
+				st.Progress.Type = st.Type
+				st.Progress.Description = st.Description
+			}
+		}
+		status.Steps = append(status.Steps, st)
+	}
+
+	return status
+}
+
+// Snapshots returns the snapshots of the step with the given index, newest
+// first. Filters are passed to restic snapshots, the host defaults to ours.
+func (b *BackupSet) Snapshots(index int, filters ...string) ([]ResticSnapshot, error) {
+	b.mu.Lock()
+	steps := b.runSteps
+	b.mu.Unlock()
+	if steps == nil {
+		steps = b.steps
+	}
+	if index < 0 || index >= len(steps) {
+		return nil, errors.New("Unknown backup step")
+	}
+
+	paths := map[string]bool{}
+	if ss, ok := steps[index].(SnapshotStep); ok {
+		for _, p := range ss.SnapshotPaths() {
+			paths[p] = true
+		}
+	} else if ss, ok := steps[index].(SummaryStep); ok {
+		for _, summary := range ss.Summaries() {
+			paths[summary.Name] = true
+		}
+	}
+	if len(paths) == 0 {
+		return []ResticSnapshot{}, nil
+	}
+
+	hosted := false
+	for _, f := range filters {
+		if strings.HasPrefix(f, "--host=") {
+			hosted = true
+		}
+	}
+	if !hosted && b.destination.hostname != "" {
+		filters = append(filters, "--host="+b.destination.hostname)
+	}
+	// Tag filters of one --tag are combined with and, several --tag with or,
+	// untagged snapshots never match a tag filter of the client
+	tagged := false
+	for i, f := range filters {
+		if strings.HasPrefix(f, "--tag=") {
+			filters[i] = "--tag=" + b.destination.tag + "," + strings.TrimPrefix(f, "--tag=")
+			tagged = true
+		}
+	}
+	if !tagged {
+		filters = append(filters, b.destination.setTagArgs()...)
+	}
+	all, err := listSnapshots(filters...)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []ResticSnapshot{}
+	for i := len(all) - 1; i >= 0; i-- {
+		for _, p := range all[i].Paths {
+			if paths[p] {
+				snapshots = append(snapshots, all[i])
+				break
+			}
+		}
+	}
+
+	return snapshots, nil
+}
+
+// runStep runs a step along with its hooks. The step is skipped if
+// the pre hook fails, the post hook is always executed.
+func (b *BackupSet) runStep(i int, s BackupStep) StepReport {
+	r := StepReport{Index: i, Type: s.Type(), Description: s.Description(), Status: "success"}
+	start := time.Now()
+
+	var hooks StepHooks
+	if h, ok := s.(HookedStep); ok {
+		hooks = h.Hooks()
+	}
+
+	env := map[string]string{
+		"RESTIC_AGENT_HOOK":             "pre",
+		"RESTIC_AGENT_STEP_INDEX":       strconv.Itoa(i),
+		"RESTIC_AGENT_STEP_TYPE":        s.Type(),
+		"RESTIC_AGENT_STEP_DESCRIPTION": s.Description(),
+	}
+
+	var err error
+	if err = hooks.Pre.Run(env); err != nil {
+		r.Status = "skipped"
+	} else if err = s.Run(b.metrics); err != nil {
+		r.Status = "failed"
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if ss, ok := s.(SummaryStep); ok && r.Status != "skipped" {
+		r.Snapshots = ss.Summaries()
+		for _, summary := range r.Snapshots {
+			b.metrics.SetSummary(summary)
+		}
+	}
+	r.Duration = time.Since(start).Seconds()
+
+	env["RESTIC_AGENT_HOOK"] = "post"
+	env["RESTIC_AGENT_STATUS"] = r.Status
+	if err != nil {
+		env["RESTIC_AGENT_ERROR"] = err.Error()
+	}
+	// A failing post hook is logged only, the backup itself is done
+	hooks.Post.Run(env)
+
+	return r
+}
+
+// Restore the given snapshot ("latest" is allowed) of the step with the given index.
+// Backups are blocked while a restore is running.
+func (b *BackupSet) Restore(index int, snapshot string) error {
+	if index < 0 || index >= len(b.steps) {
+		return errors.New("Unknown backup step")
+	}
+	s, ok := b.steps[index].(RestorableStep)
+	if !ok {
+		return errors.New("Backup step does not support restore")
+	}
+
+	if !b.running.SetIf(true, false) {
+		logger.Warn("backup already running")
+
+		return errors.New("Backup already running")
+	}
+	defer b.running.Set(false)
+
+	logger.Info("restoring backup step", zap.Int("index", index), zap.String("type", s.Type()), zap.String("description", s.Description()), zap.String("snapshot", snapshot))
+	b.publish(EventRestoreStarted, RestoreResult{Index: index, Snapshot: snapshot})
+	err := s.Restore(snapshot)
+	if err != nil {
+		logger.Error("restore failed", zap.Int("index", index), zap.String("type", s.Type()), zap.String("description", s.Description()), zap.Error(err))
+		b.publish(EventRestoreFinished, RestoreResult{Index: index, Snapshot: snapshot, Status: "failed", Error: err.Error()})
+		return err
+	}
+	logger.Info("restore finished", zap.Int("index", index), zap.String("type", s.Type()), zap.String("description", s.Description()))
+	b.publish(EventRestoreFinished, RestoreResult{Index: index, Snapshot: snapshot, Status: "success"})
+
+	return nil
 }
 
 // Check if the repository exists, try to initialize otherwise
@@ -140,7 +584,7 @@ func (b *BackupSet) InitializeRepository() error {
 
 	logger.Warn("initalizing repository")
 	// init does not support '--json' yet; but add it here so we see when support is there
-	out, err := exec.Command("restic", "init", "--json").Output()
+	out, err := resticCommand("init", "--json").Output()
 	if err != nil {
 		exiterr, ok := err.(*exec.ExitError)
 		if ok {
@@ -161,7 +605,7 @@ func (b *BackupSet) InitializeRepository() error {
 // Check if the repository exists
 func (b *BackupSet) ensureRepository() error {
 	logger.Debug("ensuring backup repository exists")
-	cmd := exec.Command("restic", "snapshots", "--json", "--latest", "1")
+	cmd := resticCommand("snapshots", "--json", "--latest", "1")
 	out, err := cmd.Output()
 	if err != nil {
 		exiterr, ok := err.(*exec.ExitError)
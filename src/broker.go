@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// broker distributes messages to Server-Sent Events clients. Slow
+// clients lose messages instead of blocking the publisher.
+type broker struct {
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+}
+
+func NewBroker() *broker {
+	return &broker{clients: map[chan []byte]bool{}}
+}
+
+func (br *broker) subscribe() chan []byte {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	ch := make(chan []byte, 64)
+	br.clients[ch] = true
+
+	return ch
+}
+
+func (br *broker) unsubscribe(ch chan []byte) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	delete(br.clients, ch)
+}
+
+// Publish sends v as json to all clients, a nil broker discards it
+func (br *broker) Publish(v interface{}) {
+	if br == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("failed to encode event", zap.Error(err))
+		return
+	}
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	for ch := range br.clients {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams the messages as text/event-stream until the client disconnects
+func (br *broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := br.subscribe()
+	defer br.unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-ch:
+			w.Write([]byte("data: "))
+			w.Write(data)
+			w.Write([]byte("\n\n"))
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CheckResult is the outcome of the last repository check
+type CheckResult struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Status   string    `json:"status"` // success or failed
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+}
+
+// Check verifies the repository structure, backups are blocked meanwhile
+func (b *BackupSet) Check() error {
+	if !b.running.SetIf(true, false) {
+		logger.Warn("backup already running")
+
+		return errors.New("Backup already running")
+	}
+	defer b.running.Set(false)
+
+	return b.check()
+}
+
+// StartCheck runs Check as goroutine and returns immediately
+func (b *BackupSet) StartCheck() error {
+	if !b.running.SetIf(true, false) {
+		logger.Warn("backup already running")
+
+		return errors.New("Backup already running")
+	}
+	go func() {
+		defer b.running.Set(false)
+
+		b.check()
+	}()
+
+	return nil
+}
+
+// LastCheck returns the result of the last check, nil if there was none
+func (b *BackupSet) LastCheck() *CheckResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.lastCheck
+}
+
+func (b *BackupSet) check() error {
+	logger.Info("checking repository")
+	r := &CheckResult{Started: time.Now(), Status: "success"}
+	b.publish(EventCheckStarted, nil)
+
+	args := append([]string{"check"}, b.destination.limitArgs()...)
+	out, err := exec.Command("restic", args...).CombinedOutput()
+	r.Finished = time.Now()
+	r.Output = string(out)
+	if err != nil {
+		logger.Error("command restic check failed", zap.ByteString("output", out), zap.Error(err))
+		r.Status = "failed"
+		r.Error = err.Error()
+	} else {
+		logger.Info("repository check finished")
+	}
+
+	b.mu.Lock()
+	b.lastCheck = r
+	b.mu.Unlock()
+	b.publish(EventCheckFinished, r)
+
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// containerAction pauses or stops containers while a volume is backed up,
+// so the files are consistent without a dump tool.
+type containerAction struct {
+	client      *dockerClient
+	action      string        // "pause" or "stop"
+	names       []string      // container names or ids
+	label       string        // select containers by label, "key" or "key=value"
+	stopTimeout int           // seconds until a stopping container is killed
+	timeout     time.Duration // maximum downtime, the backup is aborted afterwards
+
+	// Steps sharing this action run in parallel, the containers are
+	// resumed when the last of them is done
+	mu     sync.Mutex
+	users  int
+	ids    []string
+	err    error
+	paused time.Time // when before returned
+}
+
+func NewContainerAction(client *dockerClient, action string, names []string, label string) (*containerAction, error) {
+	if action != "pause" && action != "stop" {
+		return nil, errors.New("unknown container action: " + action)
+	}
+
+	a := &containerAction{}
+	a.client = client
+	a.action = action
+	a.names = names
+	a.label = label
+	a.stopTimeout = 10
+
+	return a, nil
+}
+
+func (a *containerAction) SetStopTimeout(seconds int) {
+	a.stopTimeout = seconds
+}
+
+// SetTimeout limits the downtime, 0 means no limit
+func (a *containerAction) SetTimeout(timeout time.Duration) {
+	a.timeout = timeout
+}
+
+// Timeout returns the maximum downtime, 0 means no limit
+func (a *containerAction) Timeout() time.Duration {
+	return a.timeout
+}
+
+// Description is used for logging
+func (a *containerAction) Description() string {
+	targets := append([]string{}, a.names...)
+	if a.label != "" {
+		targets = append(targets, "label:"+a.label)
+	}
+
+	return a.action + " " + strings.Join(targets, ",")
+}
+
+// before pauses or stops the running containers and returns their ids.
+// Already handled containers are returned on error, too, they have to be
+// resumed by after in any case.
+func (a *containerAction) before() ([]string, error) {
+	containers, err := a.client.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, c := range containers {
+		if c.State != "running" || !a.matches(c) {
+			continue
+		}
+
+		logger.Info("container "+a.action, zap.String("container", containerName(c)))
+		if a.action == "pause" {
+			err = a.client.PauseContainer(c.Id)
+		} else {
+			err = a.client.StopContainer(c.Id, a.stopTimeout)
+		}
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, c.Id)
+	}
+
+	return ids, nil
+}
+
+// after resumes the containers returned by before. It tries all containers
+// and returns the last error.
+func (a *containerAction) after(ids []string) (err error) {
+	for _, id := range ids {
+		var errId error
+		if a.action == "pause" {
+			errId = a.client.UnpauseContainer(id)
+		} else {
+			errId = a.client.StartContainer(id)
+		}
+		if errId != nil {
+			logger.Error("failed to resume container", zap.String("container", id), zap.Error(errId))
+			err = errId
+			continue
+		}
+		logger.Info("container resumed", zap.String("container", id))
+	}
+
+	return err
+}
+
+// Around executes fn while the containers are paused or stopped. The last
+// step sharing the action resumes the containers and gets the downtime from
+// pausing until resuming, the others get 0.
+func (a *containerAction) Around(fn func() error) (downtime time.Duration, err error) {
+	a.mu.Lock()
+	if a.users == 0 {
+		a.ids, a.err = a.before()
+		a.paused = time.Now()
+	}
+	a.users++
+	err = a.err
+	a.mu.Unlock()
+
+	defer func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		a.users--
+		if a.users == 0 {
+			errAfter := a.after(a.ids)
+			a.ids = nil
+			if err == nil {
+				err = errAfter
+			}
+			downtime = time.Since(a.paused)
+		}
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, fn()
+}
+
+func (a *containerAction) matches(c dockerContainer) bool {
+	for _, name := range a.names {
+		if name == containerName(c) || name == c.Id || (len(name) >= 12 && strings.HasPrefix(c.Id, name)) {
+			return true
+		}
+	}
+
+	if a.label == "" {
+		return false
+	}
+	key, value := a.label, ""
+	if i := strings.IndexByte(a.label, '='); i >= 0 {
+		key, value = a.label[:i], a.label[i+1:]
+	}
+	v, ok := c.Labels[key]
+
+	return ok && (value == "" || v == value)
+}
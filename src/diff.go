@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Changes are summed up per directory, up to this depth below the snapshot root
+	diffDepth = 2
+	// Number of directories in the summary
+	diffTop = 10
+	// Changes returned by the api at most
+	diffMaxChanges = 1000
+	// Directories listed per restic ls call, they are passed as arguments
+	diffListBatch = 100
+)
+
+// ResticDiffCount is the added or removed part of the statistics of "restic diff --json"
+type ResticDiffCount struct {
+	Files     int   `json:"files"`
+	Dirs      int   `json:"dirs"`
+	Others    int   `json:"others"`
+	DataBlobs int   `json:"data_blobs"`
+	TreeBlobs int   `json:"tree_blobs"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// ResticDiffStats is the statistics message of "restic diff --json"
+type ResticDiffStats struct {
+	ChangedFiles int             `json:"changed_files"`
+	Added        ResticDiffCount `json:"added"`
+	Removed      ResticDiffCount `json:"removed"`
+}
+
+// ResticChange is a change message of "restic diff --json", the size is set by the agent
+type ResticChange struct {
+	Path     string `json:"path"`
+	Modifier string `json:"modifier"` // + added, - removed, M modified, T type changed, ...
+	Size     int64  `json:"size,omitempty"`
+}
+
+// DiffPath sums up the added and modified files below a directory
+type DiffPath struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// SnapshotDiff is the difference between two snapshots
+type SnapshotDiff struct {
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Stats     ResticDiffStats `json:"stats"`
+	Top       []DiffPath      `json:"top"` // largest directories by added and modified bytes
+	Changes   []ResticChange  `json:"changes"`
+	Truncated bool            `json:"truncated,omitempty"` // more than diffMaxChanges changes
+}
+
+// diffSnapshots compares two snapshots, directories are summed up below root
+func diffSnapshots(from string, to string, root string) (*SnapshotDiff, error) {
+	if !snapshotIdPattern.MatchString(from) || !snapshotIdPattern.MatchString(to) {
+		return nil, errors.New("invalid snapshot id")
+	}
+	out, err := resticOutput("diff", "--json", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &SnapshotDiff{From: from, To: to, Top: []DiffPath{}, Changes: []ResticChange{}}
+	// sizes of added and modified files, taken from the target snapshot
+	sizes := map[string]int64{}
+	var changes []ResticChange
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			MessageType string `json:"message_type"`
+			ResticChange
+			ResticDiffStats
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		switch msg.MessageType {
+		case "change":
+			changes = append(changes, msg.ResticChange)
+			if (msg.Modifier == "+" || msg.Modifier == "M") && !strings.HasSuffix(msg.Path, "/") {
+				sizes[msg.Path] = 0
+			}
+		case "statistics":
+			d.Stats = msg.ResticDiffStats
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sizes) > 0 {
+		if err = nodeSizes(to, sizes); err != nil {
+			return nil, err
+		}
+	}
+
+	dirs := map[string]*DiffPath{}
+	for i, c := range changes {
+		size, ok := sizes[c.Path]
+		if !ok {
+			continue
+		}
+		changes[i].Size = size
+		dir := diffDirectory(c.Path, root)
+		if dirs[dir] == nil {
+			dirs[dir] = &DiffPath{Path: dir}
+		}
+		dirs[dir].Files++
+		dirs[dir].Bytes += size
+	}
+	for _, p := range dirs {
+		d.Top = append(d.Top, *p)
+	}
+	sort.Slice(d.Top, func(i, j int) bool { return d.Top[i].Bytes > d.Top[j].Bytes })
+	if len(d.Top) > diffTop {
+		d.Top = d.Top[:diffTop]
+	}
+
+	if len(changes) > diffMaxChanges {
+		changes = changes[:diffMaxChanges]
+		d.Truncated = true
+	}
+	d.Changes = append(d.Changes, changes...)
+
+	return d, nil
+}
+
+// diffDirectory returns the directory of p at most diffDepth levels below root
+func diffDirectory(p string, root string) string {
+	root = path.Clean(root)
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+	parts := strings.Split(path.Dir(rel), "/")
+	if parts[0] == "." {
+		return root
+	}
+	if len(parts) > diffDepth {
+		parts = parts[:diffDepth]
+	}
+
+	return path.Join(root, strings.Join(parts, "/"))
+}
+
+// nodeSizes sets the size of the given paths, only their directories are
+// listed instead of the whole snapshot
+func nodeSizes(snapshot string, sizes map[string]int64) error {
+	seen := map[string]bool{}
+	var dirs []string
+	for p := range sizes {
+		if dir := path.Dir(p); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	for len(dirs) > 0 {
+		n := len(dirs)
+		if n > diffListBatch {
+			n = diffListBatch
+		}
+		if err := listSizes(snapshot, dirs[:n], sizes); err != nil {
+			return err
+		}
+		dirs = dirs[n:]
+	}
+
+	return nil
+}
+
+// listSizes sets the size of the given paths from the listing of directories in a snapshot
+func listSizes(snapshot string, dirs []string, sizes map[string]int64) error {
+	cmd := resticCommand(append([]string{"ls", "--json", snapshot}, dirs...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var node ResticNode
+		if err := json.Unmarshal(scanner.Bytes(), &node); err != nil {
+			continue
+		}
+		if _, ok := sizes[node.Path]; ok {
+			sizes[node.Path] = node.Size
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		// restic blocks on the pipe which is no longer read
+		cmd.Process.Kill()
+		cmd.Wait()
+		logger.Error("failed to read restic ls output", zap.String("snapshot", snapshot), zap.Error(err))
+		return err
+	}
+	if err = cmd.Wait(); err != nil {
+		logger.Error("command restic ls failed", zap.String("snapshot", snapshot), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// Diff compares the two newest snapshots with the same paths of a step
+func (b *BackupSet) Diff(index int) (*SnapshotDiff, error) {
+	snapshots, err := b.Snapshots(index)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, errors.New("no snapshots")
+	}
+	to := snapshots[0]
+	for _, from := range snapshots[1:] {
+		if strings.Join(from.Paths, "\n") == strings.Join(to.Paths, "\n") {
+			return diffSnapshots(from.Id, to.Id, commonRoot(to.Paths))
+		}
+	}
+
+	return nil, errors.New("no previous snapshot")
+}
+
+// commonRoot returns the root of snapshot paths for summing up changes
+func commonRoot(paths []string) string {
+	if len(paths) == 1 {
+		return paths[0]
+	}
+
+	return "/"
+}
+
+// diffReporter logs the changes of each volume snapshot compared to
+// the previous snapshot of the volume and warns about large changes.
+type diffReporter struct {
+	warnBytes int64 // 0 disables the warning
+	warnFiles int   // 0 disables the warning
+}
+
+func NewDiffReporter(warnBytes int64, warnFiles int) *diffReporter {
+	return &diffReporter{warnBytes: warnBytes, warnFiles: warnFiles}
+}
+
+func (r *diffReporter) Name() string {
+	return "diff"
+}
+
+func (r *diffReporter) Notify(report *RunReport) error {
+	for _, s := range report.Steps {
+		if s.Type != "volume" || s.Status != "success" {
+			continue
+		}
+		for _, summary := range s.Snapshots {
+			if err := r.report(report, summary); err != nil {
+				logger.Warn("failed to compare snapshots", zap.String("path", summary.Name), zap.Error(err))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *diffReporter) report(report *RunReport, summary ResticSummary) error {
+	if summary.SnapshotId == "" {
+		// no snapshot was saved, e.g. with --skip-if-unchanged
+		return nil
+	}
+	snapshots, err := listSnapshots(report.snapshotFilters(summary.Name)...)
+	if err != nil {
+		return err
+	}
+
+	// find the previous snapshot, the list is sorted by time
+	previous := ""
+	for i, s := range snapshots {
+		if strings.HasPrefix(s.Id, summary.SnapshotId) && i > 0 {
+			previous = snapshots[i-1].Id
+		}
+	}
+	if previous == "" {
+		logger.Debug("no previous snapshot to compare", zap.String("path", summary.Name))
+		return nil
+	}
+
+	d, err := diffSnapshots(previous, summary.SnapshotId, summary.Name)
+	if err != nil {
+		return err
+	}
+
+	top := make([]string, 0, len(d.Top))
+	for _, p := range d.Top {
+		top = append(top, p.Path+" "+formatBytes(p.Bytes))
+	}
+	fields := []zap.Field{
+		zap.String("path", summary.Name), zap.String("from", d.From), zap.String("to", d.To),
+		zap.Int("files_added", d.Stats.Added.Files), zap.Int("files_removed", d.Stats.Removed.Files),
+		zap.Int("files_changed", d.Stats.ChangedFiles), zap.Int64("bytes_added", d.Stats.Added.Bytes),
+		zap.Int64("bytes_removed", d.Stats.Removed.Bytes), zap.Strings("top", top),
+	}
+	if (r.warnBytes > 0 && d.Stats.Added.Bytes >= r.warnBytes) ||
+		(r.warnFiles > 0 && d.Stats.Added.Files+d.Stats.ChangedFiles >= r.warnFiles) {
+		logger.Warn("large change of volume", fields...)
+		return nil
+	}
+	logger.Info("volume changes", fields...)
+
+	return nil
+}
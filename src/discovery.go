@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// dockerDiscovery builds backup steps from labels of running containers:
+//
+//	restic-agent.volume=/data/app,/data/uploads   paths as mounted into the agent
+//	restic-agent.volume.exclude=*.tmp,*.log       volume options like exclude-caches=true, see VolumeOptions
+//	restic-agent.action=pause                     pause or stop the container during volume backups
+//	restic-agent.sqlite=/data/app/app.db          database file or directory
+//	restic-agent.postgres=true                    dump the database of this container
+//	restic-agent.mariadb=true                     dump the database of this container
+//	restic-agent.pre-hook=command                 hooks for all steps of this container
+//	restic-agent.post-hook=command
+//	restic-agent.tags=tag1,tag2                   tags of the snapshots of this container
+//	restic-agent.priority=10                      steps with higher priority start first
+//	restic-agent.after=postgres,volume:/data/app  start the steps of this container after these steps
+//
+// Database credentials are taken from the environment of the container
+// (as used by the official images) and can be overwritten by labels like
+// restic-agent.postgres.host, .user, .password, .db or .all.
+type dockerDiscovery struct {
+	client      *dockerClient
+	prefix      string
+	hookTimeout time.Duration
+	tags        []string
+	options     VolumeOptions
+}
+
+func NewDockerDiscovery(client *dockerClient, prefix string) *dockerDiscovery {
+	return &dockerDiscovery{client: client, prefix: prefix}
+}
+
+// SetHookTimeout sets the timeout of hooks defined by labels
+func (d *dockerDiscovery) SetHookTimeout(timeout time.Duration) {
+	d.hookTimeout = timeout
+}
+
+// SetTags sets the tags of all discovered steps, labels add more tags
+func (d *dockerDiscovery) SetTags(tags []string) {
+	d.tags = tags
+}
+
+// SetVolumeOptions sets the options of all discovered volumes, labels add more options
+func (d *dockerDiscovery) SetVolumeOptions(o VolumeOptions) {
+	d.options = o
+}
+
+func (d *dockerDiscovery) Discover() ([]BackupStep, error) {
+	containers, err := d.client.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []BackupStep
+	for _, c := range containers {
+		s, err := d.containerSteps(c)
+		if err != nil {
+			// A single misconfigured container must not prevent other backups
+			logger.Error("failed to discover backup steps", zap.String("container", containerName(c)), zap.Error(err))
+			continue
+		}
+		steps = append(steps, s...)
+	}
+
+	return steps, nil
+}
+
+func (d *dockerDiscovery) containerSteps(c dockerContainer) ([]BackupStep, error) {
+	var steps []BackupStep
+
+	var action *containerAction
+	if a := d.label(c, "action"); a != "" {
+		var err error
+		action, err = NewContainerAction(d.client, a, []string{c.Id}, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	options, err := d.volumeOptions(c)
+	if err != nil {
+		return nil, err
+	}
+	// The order of the container is the default of its volumes, which may
+	// overwrite it by their options
+	priority := 0
+	if p := d.label(c, "priority"); p != "" {
+		if priority, err = strconv.Atoi(p); err != nil {
+			return nil, errors.New("invalid priority label: " + p)
+		}
+		options.Priority = priority
+	}
+	after := d.labelList(c, "after")
+	options.After = append(options.After, after...)
+	for _, v := range d.labelList(c, "volume") {
+		path, options, err := ParseVolume(v, options)
+		if err != nil {
+			return nil, err
+		}
+		s := NewVolumeStep(path)
+		s.SetOptions(options)
+		if action != nil {
+			s.SetContainerAction(action)
+		}
+		steps = append(steps, s)
+	}
+	for _, path := range d.labelList(c, "sqlite") {
+		steps = append(steps, NewSqliteStep(path))
+	}
+
+	if d.label(c, "postgres") == "true" {
+		s, err := d.postgresStep(c)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+	if d.label(c, "mariadb") == "true" {
+		s, err := d.mariadbStep(c)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+
+	hooks := StepHooks{
+		Pre:  NewHook(d.label(c, "pre-hook"), d.hookTimeout),
+		Post: NewHook(d.label(c, "post-hook"), d.hookTimeout),
+	}
+	tags := append(append([]string{}, d.tags...), d.labelList(c, "tags")...)
+	for _, s := range steps {
+		if h, ok := s.(interface{ SetHooks(StepHooks) }); ok {
+			h.SetHooks(hooks)
+		}
+		if t, ok := s.(interface{ SetTags([]string) }); ok {
+			t.SetTags(tags)
+		}
+		if _, ok := s.(*volumeStep); ok {
+			continue
+		}
+		if o, ok := s.(interface{ SetOrder(int, []string) }); ok {
+			o.SetOrder(priority, after)
+		}
+	}
+
+	return steps, nil
+}
+
+func (d *dockerDiscovery) postgresStep(c dockerContainer) (BackupStep, error) {
+	details, err := d.client.InspectContainer(c.Id)
+	if err != nil {
+		return nil, err
+	}
+	env := containerEnv(details)
+
+	host := d.labelOr(c, "postgres.host", containerName(c))
+	user := d.labelOr(c, "postgres.user", firstNonEmpty(env["POSTGRES_USER"], "postgres"))
+	password := d.labelOr(c, "postgres.password", env["POSTGRES_PASSWORD"])
+	database := d.labelOr(c, "postgres.db", firstNonEmpty(env["POSTGRES_DB"], user))
+	all := d.label(c, "postgres.all") == "true"
+	if all {
+		database = ""
+	}
+
+	s, err := NewPostgresStep(host, user, password, database)
+	if err != nil {
+		return nil, err
+	}
+	s.SetAllDatabases(all)
+
+	return s, nil
+}
+
+func (d *dockerDiscovery) mariadbStep(c dockerContainer) (BackupStep, error) {
+	details, err := d.client.InspectContainer(c.Id)
+	if err != nil {
+		return nil, err
+	}
+	env := containerEnv(details)
+
+	host := d.labelOr(c, "mariadb.host", containerName(c))
+	user := firstNonEmpty(env["MARIADB_USER"], env["MYSQL_USER"])
+	password := firstNonEmpty(env["MARIADB_PASSWORD"], env["MYSQL_PASSWORD"])
+	if user == "" {
+		user = "root"
+		password = firstNonEmpty(env["MARIADB_ROOT_PASSWORD"], env["MYSQL_ROOT_PASSWORD"])
+	}
+	user = d.labelOr(c, "mariadb.user", user)
+	password = d.labelOr(c, "mariadb.password", password)
+	database := d.labelOr(c, "mariadb.db", firstNonEmpty(env["MARIADB_DATABASE"], env["MYSQL_DATABASE"]))
+
+	s, err := NewMariadbStep(host, user, password, database)
+	if err != nil {
+		return nil, err
+	}
+	s.SetAllDatabases(d.label(c, "mariadb.all") == "true" || database == "")
+
+	return s, nil
+}
+
+// volumeOptions applies labels like restic-agent.volume.exclude to the default options
+func (d *dockerDiscovery) volumeOptions(c dockerContainer) (VolumeOptions, error) {
+	o := d.options.copy()
+	for _, name := range []string{"exclude", "iexclude", "exclude-if-present", "files-from"} {
+		for _, value := range d.labelList(c, "volume."+name) {
+			if err := o.Set(name, value); err != nil {
+				return o, err
+			}
+		}
+	}
+	for _, name := range []string{"exclude-caches", "exclude-larger-than", "one-file-system", "skip-if-unchanged"} {
+		if value := d.label(c, "volume."+name); value != "" {
+			if err := o.Set(name, value); err != nil {
+				return o, err
+			}
+		}
+	}
+
+	return o, nil
+}
+
+func (d *dockerDiscovery) label(c dockerContainer, name string) string {
+	return strings.TrimSpace(c.Labels[d.prefix+"."+name])
+}
+
+func (d *dockerDiscovery) labelOr(c dockerContainer, name string, fallback string) string {
+	return firstNonEmpty(d.label(c, name), fallback)
+}
+
+// labelList splits a comma separated label value
+func (d *dockerDiscovery) labelList(c dockerContainer, name string) []string {
+	var values []string
+	for _, v := range strings.Split(d.label(c, name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDocker serves the parts of the Docker Engine API used by the agent on a unix socket
+type fakeDocker struct {
+	containers []dockerContainer
+	env        map[string][]string // container id to environment
+	gone       map[string]bool     // listed, but removed before inspect
+
+	mu       sync.Mutex
+	requests []string // "POST /containers/{id}/pause"
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.URL.Path == "/containers/json" {
+		json.NewEncoder(w).Encode(f.containers)
+		return
+	}
+	for _, c := range f.containers {
+		if r.URL.Path == "/containers/"+c.Id+"/json" && !f.gone[c.Id] {
+			details := dockerContainerDetails{Id: c.Id, Name: "/" + containerName(c)}
+			details.Config.Env = f.env[c.Id]
+			details.Config.Labels = c.Labels
+			json.NewEncoder(w).Encode(details)
+			return
+		}
+	}
+	http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+}
+
+// startFakeDocker returns a client connected to the fake server
+func startFakeDocker(t *testing.T, f *fakeDocker) *dockerClient {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(f)
+	server.Listener = l
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client, err := NewDockerClient("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestDockerDiscovery(t *testing.T) {
+	f := &fakeDocker{
+		containers: []dockerContainer{
+			{Id: "a1", Names: []string{"/app"}, Labels: map[string]string{
+				"restic-agent.volume":                "/data/app;exclude=*.tmp, /data/uploads",
+				"restic-agent.volume.exclude-caches": "true",
+				"restic-agent.sqlite":                "/data/app/app.db",
+				"restic-agent.tags":                  "web, app",
+				"restic-agent.priority":              "5",
+				"restic-agent.after":                 "postgres",
+			}},
+			{Id: "b2", Names: []string{"/db"}, Labels: map[string]string{
+				"restic-agent.postgres":    "true",
+				"restic-agent.postgres.db": "appdb",
+			}},
+			{Id: "c3", Names: []string{"/maria"}, Labels: map[string]string{
+				"restic-agent.mariadb": "true",
+			}},
+			{Id: "d4", Names: []string{"/gone"}, Labels: map[string]string{
+				"restic-agent.volume":   "/data/gone",
+				"restic-agent.postgres": "true",
+			}},
+			{Id: "f6", Names: []string{"/broken"}, Labels: map[string]string{
+				"restic-agent.volume": "/data/broken;no-such-option",
+			}},
+			{Id: "e5", Names: []string{"/other"}, Labels: map[string]string{
+				"other.volume": "/data/other",
+			}},
+		},
+		env: map[string][]string{
+			"b2": {"POSTGRES_USER=app", "POSTGRES_PASSWORD=secret"},
+			"c3": {"MYSQL_ROOT_PASSWORD=root", "MYSQL_DATABASE=shop"},
+		},
+		gone: map[string]bool{"d4": true},
+	}
+	d := NewDockerDiscovery(startFakeDocker(t, f), "restic-agent")
+	d.SetTags([]string{"prod"})
+
+	steps, err := d.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a container failing discovery is skipped as a whole
+	tests := []struct {
+		step     string
+		tags     []string
+		priority int
+		after    []string
+	}{
+		{"volume:/data/app", []string{"prod", "web", "app"}, 5, []string{"postgres"}},
+		{"volume:/data/uploads", []string{"prod", "web", "app"}, 5, []string{"postgres"}},
+		{"sqlite:/data/app/app.db", []string{"prod", "web", "app"}, 5, []string{"postgres"}},
+		{"postgres:app@db/appdb", []string{"prod"}, 0, nil},
+		{"mariadb:root@maria/shop", []string{"prod"}, 0, nil},
+	}
+	if len(steps) != len(tests) {
+		t.Fatalf("discovered %d steps, expected %d", len(steps), len(tests))
+	}
+	for i, tt := range tests {
+		s := steps[i]
+		if name := s.Type() + ":" + s.Description(); name != tt.step {
+			t.Errorf("step %d is %s, expected %s", i, name, tt.step)
+			continue
+		}
+		if tags := s.(TaggedStep).Tags(); !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("%s: tags %v, expected %v", tt.step, tags, tt.tags)
+		}
+		o := s.(OrderedStep)
+		if o.Priority() != tt.priority || !reflect.DeepEqual(o.After(), tt.after) {
+			t.Errorf("%s: order %d %v, expected %d %v", tt.step, o.Priority(), o.After(), tt.priority, tt.after)
+		}
+	}
+
+	if args := strings.Join(steps[0].(*volumeStep).options.args(), " "); args != "--exclude=*.tmp --exclude-caches" {
+		t.Errorf("volume options %q", args)
+	}
+	if args := strings.Join(steps[1].(*volumeStep).options.args(), " "); args != "--exclude-caches" {
+		t.Errorf("volume options of the second volume %q", args)
+	}
+	if db := steps[3].(*postgresStep); db.password != "secret" {
+		t.Errorf("postgres password %q", db.password)
+	}
+	if db := steps[4].(*mariadbStep); db.password != "root" {
+		t.Errorf("mariadb password %q", db.password)
+	}
+}
+
+func TestDockerDiscoveryVolumeOrder(t *testing.T) {
+	f := &fakeDocker{containers: []dockerContainer{
+		{Id: "a1", Names: []string{"/app"}, Labels: map[string]string{
+			"restic-agent.volume":   "/data/app,/data/wal;priority=9;after=postgres",
+			"restic-agent.priority": "2",
+			"restic-agent.after":    "sqlite",
+		}},
+	}}
+	d := NewDockerDiscovery(startFakeDocker(t, f), "restic-agent")
+	d.SetVolumeOptions(VolumeOptions{Priority: 1, After: []string{"redis"}})
+
+	steps, err := d.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		priority int
+		after    []string
+	}{
+		{2, []string{"redis", "sqlite"}},
+		{9, []string{"redis", "sqlite", "postgres"}},
+	}
+	if len(steps) != len(tests) {
+		t.Fatalf("discovered %d steps, expected %d", len(steps), len(tests))
+	}
+	for i, tt := range tests {
+		o := steps[i].(OrderedStep)
+		if o.Priority() != tt.priority || !reflect.DeepEqual(o.After(), tt.after) {
+			t.Errorf("step %d: order %d %v, expected %d %v", i, o.Priority(), o.After(), tt.priority, tt.after)
+		}
+	}
+}
+
+func TestDockerClient(t *testing.T) {
+	f := &fakeDocker{containers: []dockerContainer{{Id: "a1", Names: []string{"/app"}}}}
+	client := startFakeDocker(t, f)
+
+	containers, err := client.ListContainers()
+	if err != nil || len(containers) != 1 || containerName(containers[0]) != "app" {
+		t.Errorf("list: %v %v", containers, err)
+	}
+	if _, err := client.InspectContainer("missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("inspect of a missing container: %v", err)
+	}
+	details, err := client.InspectContainer("a1")
+	if err != nil || details.Name != "/app" {
+		t.Errorf("inspect: %v %v", details, err)
+	}
+	if err := client.PauseContainer("a1"); err != nil {
+		t.Error(err)
+	}
+	if err := client.StopContainer("a1", 10); err != nil {
+		t.Error(err)
+	}
+	expected := []string{"POST /containers/a1/pause", "POST /containers/a1/stop"}
+	if !reflect.DeepEqual(f.requests, expected) {
+		t.Errorf("requests %v, expected %v", f.requests, expected)
+	}
+
+	if _, err := NewDockerClient("ftp://docker"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dockerClient is a minimal client for the Docker Engine API, see
+// https://docs.docker.com/engine/api/
+type dockerClient struct {
+	client  *http.Client
+	baseUrl string
+}
+
+// dockerContainer is the subset of /containers/json used by the agent
+type dockerContainer struct {
+	Id     string
+	Names  []string
+	Labels map[string]string
+	State  string
+}
+
+// dockerContainerDetails is the subset of /containers/{id}/json used by the agent
+type dockerContainerDetails struct {
+	Id     string
+	Name   string
+	Config struct {
+		Env    []string
+		Labels map[string]string
+	}
+}
+
+// NewDockerClient connects to "unix:///path/to/docker.sock" or "tcp://host:port"
+func NewDockerClient(host string) (*dockerClient, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dockerClient{}
+	switch u.Scheme {
+	case "unix":
+		d.baseUrl = "http://docker"
+		d.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", u.Path)
+				},
+			},
+		}
+	case "tcp", "http":
+		d.baseUrl = "http://" + u.Host
+		d.client = &http.Client{}
+	default:
+		return nil, errors.New("unsupported docker host: " + host)
+	}
+	// Stopping a container waits for its stop timeout, so be generous
+	d.client.Timeout = 5 * time.Minute
+
+	return d, nil
+}
+
+// ListContainers returns the running containers
+func (d *dockerClient) ListContainers() ([]dockerContainer, error) {
+	var containers []dockerContainer
+	err := d.request("GET", "/containers/json", &containers)
+
+	return containers, err
+}
+
+// InspectContainer returns details like the environment of a container
+func (d *dockerClient) InspectContainer(id string) (*dockerContainerDetails, error) {
+	details := &dockerContainerDetails{}
+	err := d.request("GET", "/containers/"+url.PathEscape(id)+"/json", details)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+func (d *dockerClient) PauseContainer(id string) error {
+	return d.request("POST", "/containers/"+url.PathEscape(id)+"/pause", nil)
+}
+
+func (d *dockerClient) UnpauseContainer(id string) error {
+	return d.request("POST", "/containers/"+url.PathEscape(id)+"/unpause", nil)
+}
+
+// StopContainer stops a container, it is killed after timeout seconds
+func (d *dockerClient) StopContainer(id string, timeout int) error {
+	return d.request("POST", "/containers/"+url.PathEscape(id)+"/stop?t="+strconv.Itoa(timeout), nil)
+}
+
+func (d *dockerClient) StartContainer(id string) error {
+	return d.request("POST", "/containers/"+url.PathEscape(id)+"/start", nil)
+}
+
+func (d *dockerClient) request(method string, path string, result interface{}) error {
+	req, err := http.NewRequest(method, d.baseUrl+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// 304 Not Modified: container already started, stopped or paused
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		return errors.New("docker api " + method + " " + path + ": " + resp.Status + ": " + strings.TrimSpace(string(body)))
+	}
+	if result == nil || len(body) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+// containerName returns the name of a container without the leading slash
+func containerName(c dockerContainer) string {
+	if len(c.Names) == 0 {
+		return c.Id
+	}
+
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// containerEnv returns a container environment as map
+func containerEnv(d *dockerContainerDetails) map[string]string {
+	env := map[string]string{}
+	for _, e := range d.Config.Env {
+		if i := strings.IndexByte(e, '='); i > 0 {
+			env[e[:i]] = e[i+1:]
+		}
+	}
+
+	return env
+}
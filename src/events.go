@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+)
+
+// Event types published on the event stream
+const (
+	EventRunStarted      = "run_started"
+	EventRunFinished     = "run_finished"
+	EventRunDeferred     = "run_deferred"
+	EventStepStarted     = "step_started"
+	EventStepFinished    = "step_finished"
+	EventProgress        = "progress"
+	EventRestoreStarted  = "restore_started"
+	EventRestoreFinished = "restore_finished"
+	EventCheckStarted    = "check_started"
+	EventCheckFinished   = "check_finished"
+)
+
+// Event is a message of the event stream, data depends on the type:
+// RunReport for runs, the end of the blackout for deferred runs, StepStatus and StepReport for steps, StepProgress
+// for progress, RestoreResult for restores and CheckResult for checks.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Set  string      `json:"set"`
+	Host string      `json:"host"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// RestoreResult is the data of restore events
+type RestoreResult struct {
+	Index    int    `json:"index"`
+	Snapshot string `json:"snapshot"`
+	Status   string `json:"status,omitempty"` // success or failed, finished only
+	Error    string `json:"error,omitempty"`
+}
+
+// SetEventBroker publishes the events of the backup set
+func (b *BackupSet) SetEventBroker(br *broker) {
+	b.events = br
+}
+
+func (b *BackupSet) publish(eventType string, data interface{}) {
+	b.events.Publish(Event{Type: eventType, Time: time.Now(), Set: b.name, Host: b.destination.hostname, Data: data})
+}
@@ -1,6 +1,6 @@
 module github.com/clemens321/restic-agent
 
-go 1.12
+go 1.16
 
 require (
 	github.com/kelseyhightower/envconfig v1.4.0
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+)
+
+// runHistory keeps the reports of the last runs in memory, it is lost on restart
+type runHistory struct {
+	mu      sync.Mutex
+	size    int
+	reports []*RunReport
+}
+
+func NewRunHistory(size int) *runHistory {
+	return &runHistory{size: size}
+}
+
+func (h *runHistory) Name() string {
+	return "history"
+}
+
+func (h *runHistory) Notify(r *RunReport) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.reports = append(h.reports, r)
+	if len(h.reports) > h.size {
+		h.reports = h.reports[len(h.reports)-h.size:]
+	}
+
+	return nil
+}
+
+// Reports returns the reports, newest first
+func (h *runHistory) Reports() []*RunReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	reports := make([]*RunReport, 0, len(h.reports))
+	for i := len(h.reports) - 1; i >= 0; i-- {
+		reports = append(reports, h.reports[i])
+	}
+
+	return reports
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Hook is a shell command executed before or after a step or backup set
+type Hook struct {
+	command string
+	timeout time.Duration
+}
+
+// StepHooks are the optional pre and post hooks of a step or backup set.
+// A failing pre hook skips the step, post hooks are always executed.
+type StepHooks struct {
+	Pre  *Hook
+	Post *Hook
+}
+
+// hookable can be embedded into steps to make them configurable with hooks
+type hookable struct {
+	hooks StepHooks
+}
+
+// HookedStep is implemented by steps which have hooks
+type HookedStep interface {
+	Hooks() StepHooks
+}
+
+// NewHook returns nil for an empty command, so unset hooks need no checks
+func NewHook(command string, timeout time.Duration) *Hook {
+	if command == "" {
+		return nil
+	}
+
+	return &Hook{command: command, timeout: timeout}
+}
+
+func (h *hookable) SetHooks(hooks StepHooks) {
+	h.hooks = hooks
+}
+
+func (h *hookable) Hooks() StepHooks {
+	return h.hooks
+}
+
+// Run executes the hook with "sh -c", env is added to the agent environment.
+// A nil hook does nothing.
+func (h *Hook) Run(env map[string]string) error {
+	if h == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.Command("sh", "-c", h.command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	output := bytes.NewBuffer(nil)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	logger.Debug("running hook", zap.String("command", h.command), zap.String("hook", env["RESTIC_AGENT_HOOK"]))
+	err := cmd.Start()
+	if err == nil {
+		// Background commands inherit the output pipe, so the whole process
+		// group is killed on timeout, not only sh
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		logger.Error("hook failed", zap.String("command", h.command), zap.String("hook", env["RESTIC_AGENT_HOOK"]),
+			zap.String("output", output.String()), zap.Error(err),
+		)
+		return err
+	}
+	logger.Info("hook finished", zap.String("command", h.command), zap.String("hook", env["RESTIC_AGENT_HOOK"]),
+		zap.String("output", output.String()),
+	)
+
+	return nil
+}
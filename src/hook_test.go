@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHookTimeout(t *testing.T) {
+	for _, command := range []string{
+		"sleep 60",
+		"sleep 60 & wait",
+		"(sleep 60; echo done) & echo started; wait",
+	} {
+		h := NewHook(command, 200*time.Millisecond)
+		start := time.Now()
+		err := h.Run(nil)
+		if err == nil {
+			t.Errorf("%q: expected timeout error", command)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%q: returned after %s", command, d)
+		}
+	}
+}
+
+func TestHookRun(t *testing.T) {
+	tests := []struct {
+		command string
+		env     map[string]string
+		fail    bool
+	}{
+		{"true", nil, false},
+		{"exit 3", nil, true},
+		{`test "$RESTIC_AGENT_HOOK" = post`, map[string]string{"RESTIC_AGENT_HOOK": "post"}, false},
+		{`test "$RESTIC_AGENT_HOOK" = post`, map[string]string{"RESTIC_AGENT_HOOK": "pre"}, true},
+	}
+	for _, tt := range tests {
+		err := NewHook(tt.command, time.Minute).Run(tt.env)
+		if (err != nil) != tt.fail {
+			t.Errorf("%q with %v: error %v, expected failure %v", tt.command, tt.env, err, tt.fail)
+		}
+	}
+}
+
+func TestNilHook(t *testing.T) {
+	if h := NewHook("", time.Minute); h != nil {
+		t.Fatal("expected nil hook for empty command")
+	}
+	var h *Hook
+	if err := h.Run(nil); err != nil {
+		t.Errorf("nil hook: %v", err)
+	}
+}
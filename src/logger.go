@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -9,6 +11,9 @@ import (
 
 var logger *zap.Logger
 
+// logTail keeps the last log lines, e.g. for failure pings
+var logTail = newTailBuffer(200)
+
 func init() {
 	var (
 		debug  = os.Getenv("DEBUG")
@@ -26,8 +31,68 @@ func init() {
 		config.DisableCaller = true
 	}
 
-	logger, err = config.Build()
+	tail := zapcore.NewCore(zapcore.NewJSONEncoder(config.EncoderConfig), zapcore.AddSync(logTail), config.Level)
+	logger, err = config.Build(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, tail)
+	}))
 	if err != nil {
 		panic(err)
 	}
 }
+
+// tailBuffer is a thread-safe ring buffer of lines, it implements io.Writer
+type tailBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	written int // lines written in total
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{lines: make([]string, size)}
+}
+
+// Write stores p as one line, zap writes one entry per call
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lines[t.next] = strings.TrimRight(string(p), "\n")
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+	t.written++
+
+	return len(p), nil
+}
+
+// String returns the stored lines, oldest first
+func (t *tailBuffer) String() string {
+	return t.Since(0)
+}
+
+// Position returns the number of lines written so far, for Since
+func (t *tailBuffer) Position() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.written
+}
+
+// Since returns the stored lines written after Position returned pos, oldest first
+func (t *tailBuffer) Since(pos int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := t.lines[:t.next]
+	if t.full {
+		lines = append(append([]string{}, t.lines[t.next:]...), t.lines[:t.next]...)
+	}
+	if n := t.written - pos; n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
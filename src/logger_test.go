@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestTailBufferSince(t *testing.T) {
+	b := newTailBuffer(3)
+	b.Write([]byte("a\n"))
+	pos := b.Position()
+	if lines := b.Since(pos); lines != "" {
+		t.Errorf("no new lines: %q", lines)
+	}
+
+	b.Write([]byte("b\n"))
+	if lines := b.Since(pos); lines != "b" {
+		t.Errorf("one new line: %q", lines)
+	}
+
+	// older lines are overwritten, only the stored ones are returned
+	b.Write([]byte("c\n"))
+	b.Write([]byte("d\n"))
+	b.Write([]byte("e\n"))
+	if lines := b.Since(pos); lines != "c\nd\ne" {
+		t.Errorf("wrapped: %q", lines)
+	}
+	if lines := b.String(); lines != "c\nd\ne" {
+		t.Errorf("all lines: %q", lines)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pborman/getopt/v2"
@@ -17,23 +18,159 @@ type config struct {
 	Repository         string `envconfig:"RESTIC_REPOSITORY"`
 	Password           string `envconfig:"RESTIC_PASSWORD"`
 	Hostname           string `envconfig:"RESTIC_HOSTNAME"`
+	SetName            string `envconfig:"SET_NAME" default:"default"`
 	RunOnStartup       bool   `envconfig:"RUN_ON_STARTUP"`
 	Schedule           string `envconfig:"SCHEDULE"`
 	ListenAddress      string `envconfig:"LISTEN_ADDRESS"`
 	ListenPort         int    `envconfig:"LISTEN_PORT" default:"80"`
 	PrometheusEndpoint string `envconfig:"PROMETHEUS_ENDPOINT" default:"/metrics"`
+	PushgatewayUrl     string `envconfig:"PUSHGATEWAY_URL"`
+	PushgatewayJob     string `envconfig:"PUSHGATEWAY_JOB" default:"restic-agent"`
+	MetricsTextfile    string `envconfig:"METRICS_TEXTFILE"`
+	StatsSchedule      string `envconfig:"STATS_SCHEDULE"`
+	StatsAfterBackup   bool   `envconfig:"STATS_AFTER_BACKUP"`
+	HistorySize        int    `envconfig:"HISTORY_SIZE" default:"50"`
+	DiffSummary        bool   `envconfig:"DIFF_SUMMARY"`
+	DiffWarnBytes      string `envconfig:"DIFF_WARN_BYTES"`
+	DiffWarnFiles      int    `envconfig:"DIFF_WARN_FILES"`
+
+	WebhookUrls          []string `envconfig:"WEBHOOK_URLS"`
+	WebhookFormat        string   `envconfig:"WEBHOOK_FORMAT" default:"json"`
+	WebhookOnFailureOnly bool     `envconfig:"WEBHOOK_ON_FAILURE_ONLY"`
+	WebhookRetries       int      `envconfig:"WEBHOOK_RETRIES" default:"3"`
+
+	PingUrl string `envconfig:"PING_URL"`
+
+	SmtpHost           string   `envconfig:"SMTP_HOST"`
+	SmtpPort           int      `envconfig:"SMTP_PORT" default:"587"`
+	SmtpSecurity       string   `envconfig:"SMTP_SECURITY" default:"starttls"`
+	SmtpUsername       string   `envconfig:"SMTP_USERNAME"`
+	SmtpPassword       string   `envconfig:"SMTP_PASSWORD"`
+	SmtpFrom           string   `envconfig:"SMTP_FROM"`
+	SmtpTo             []string `envconfig:"SMTP_TO"`
+	SmtpOnFailureOnly  bool     `envconfig:"SMTP_ON_FAILURE_ONLY"`
+	SmtpDigestSchedule string   `envconfig:"SMTP_DIGEST_SCHEDULE"`
+	SmtpTemplateText   string   `envconfig:"SMTP_TEMPLATE_TEXT"`
+	SmtpTemplateHtml   string   `envconfig:"SMTP_TEMPLATE_HTML"`
+
+	PreHook     string        `envconfig:"PRE_HOOK"`
+	PostHook    string        `envconfig:"POST_HOOK"`
+	HookTimeout time.Duration `envconfig:"HOOK_TIMEOUT" default:"5m"`
+
+	Tags         []string `envconfig:"TAGS"`
+	VolumeTags   []string `envconfig:"VOLUME_TAGS"`
+	SqliteTags   []string `envconfig:"SQLITE_TAGS"`
+	PostgresTags []string `envconfig:"POSTGRES_TAGS"`
+	MysqlTags    []string `envconfig:"MYSQL_TAGS"`
+	MongodbTags  []string `envconfig:"MONGODB_TAGS"`
+	RedisTags    []string `envconfig:"REDIS_TAGS"`
+
+	// semicolon separated, as after the path of --volume
+	VolumeOptions string `envconfig:"VOLUME_OPTIONS"`
+
+	VolumePreHook    string `envconfig:"VOLUME_PRE_HOOK"`
+	VolumePostHook   string `envconfig:"VOLUME_POST_HOOK"`
+	SqlitePreHook    string `envconfig:"SQLITE_PRE_HOOK"`
+	SqlitePostHook   string `envconfig:"SQLITE_POST_HOOK"`
+	PostgresPreHook  string `envconfig:"POSTGRES_PRE_HOOK"`
+	PostgresPostHook string `envconfig:"POSTGRES_POST_HOOK"`
+	MysqlPreHook     string `envconfig:"MYSQL_PRE_HOOK"`
+	MysqlPostHook    string `envconfig:"MYSQL_POST_HOOK"`
+	MongodbPreHook   string `envconfig:"MONGODB_PRE_HOOK"`
+	MongodbPostHook  string `envconfig:"MONGODB_POST_HOOK"`
+	RedisPreHook     string `envconfig:"REDIS_PRE_HOOK"`
+	RedisPostHook    string `envconfig:"REDIS_POST_HOOK"`
+
+	LimitUpload          int      `envconfig:"LIMIT_UPLOAD"`
+	LimitDownload        int      `envconfig:"LIMIT_DOWNLOAD"`
+	LimitUploadWindows   []string `envconfig:"LIMIT_UPLOAD_WINDOWS"`
+	LimitDownloadWindows []string `envconfig:"LIMIT_DOWNLOAD_WINDOWS"`
+	BlackoutWindows      []string `envconfig:"BLACKOUT_WINDOWS"`
+
+	// 0 runs all steps at once, SEQUENTIAL is the same as 1
+	MaxParallel      int      `envconfig:"MAX_PARALLEL"`
+	Sequential       bool     `envconfig:"SEQUENTIAL"`
+	VolumePriority   int      `envconfig:"VOLUME_PRIORITY"`
+	VolumeAfter      []string `envconfig:"VOLUME_AFTER"`
+	SqlitePriority   int      `envconfig:"SQLITE_PRIORITY"`
+	SqliteAfter      []string `envconfig:"SQLITE_AFTER"`
+	PostgresPriority int      `envconfig:"POSTGRES_PRIORITY"`
+	PostgresAfter    []string `envconfig:"POSTGRES_AFTER"`
+	MysqlPriority    int      `envconfig:"MYSQL_PRIORITY"`
+	MysqlAfter       []string `envconfig:"MYSQL_AFTER"`
+	MongodbPriority  int      `envconfig:"MONGODB_PRIORITY"`
+	MongodbAfter     []string `envconfig:"MONGODB_AFTER"`
+	RedisPriority    int      `envconfig:"REDIS_PRIORITY"`
+	RedisAfter       []string `envconfig:"REDIS_AFTER"`
+
+	AnomalyFilesFactor float64 `envconfig:"ANOMALY_FILES_FACTOR"`
+	AnomalyBytesFactor float64 `envconfig:"ANOMALY_BYTES_FACTOR"`
+	AnomalyWindow      int     `envconfig:"ANOMALY_WINDOW" default:"10"`
+	AnomalyMinFiles    int64   `envconfig:"ANOMALY_MIN_FILES" default:"100"`
+	AnomalyMinBytes    string  `envconfig:"ANOMALY_MIN_BYTES" default:"100MiB"`
+	AnomalyBlockForget bool    `envconfig:"ANOMALY_BLOCK_FORGET"`
+
+	DockerDiscovery   bool   `envconfig:"DOCKER_DISCOVERY"`
+	DockerHost        string `envconfig:"DOCKER_HOST" default:"unix:///var/run/docker.sock"`
+	DockerLabelPrefix string `envconfig:"DOCKER_LABEL_PREFIX" default:"restic-agent"`
+
+	ContainerAction      string        `envconfig:"CONTAINER_ACTION"`
+	ContainerNames       []string      `envconfig:"CONTAINER_NAMES"`
+	ContainerLabel       string        `envconfig:"CONTAINER_LABEL"`
+	ContainerStopTimeout int           `envconfig:"CONTAINER_STOP_TIMEOUT" default:"10"`
+	ContainerTimeout     time.Duration `envconfig:"CONTAINER_TIMEOUT"`
 
 	PostgresName     string `envconfig:"POSTGRES_NAME"`
 	PostgresHost     string `envconfig:"POSTGRES_HOST"`
 	PostgresDatabase string `envconfig:"POSTGRES_DB"`
 	PostgresPassword string `envconfig:"POSTGRES_PASSWORD"`
 	PostgresUser     string `envconfig:"POSTGRES_USER"`
+	PostgresAll      bool   `envconfig:"POSTGRES_ALL_DATABASES"`
+
+	PostgresSslMode     string `envconfig:"POSTGRES_SSLMODE"`
+	PostgresSslCert     string `envconfig:"POSTGRES_SSLCERT"`
+	PostgresSslKey      string `envconfig:"POSTGRES_SSLKEY"`
+	PostgresSslRootCert string `envconfig:"POSTGRES_SSLROOTCERT"`
+
+	PostgresFormat           string   `envconfig:"POSTGRES_FORMAT"`
+	PostgresCompress         int      `envconfig:"POSTGRES_COMPRESS" default:"-1"`
+	PostgresJobs             int      `envconfig:"POSTGRES_JOBS"`
+	PostgresDumpDir          string   `envconfig:"POSTGRES_DUMP_DIR"`
+	PostgresSchemas          []string `envconfig:"POSTGRES_SCHEMA"`
+	PostgresExcludeTable     []string `envconfig:"POSTGRES_EXCLUDE_TABLE"`
+	PostgresExcludeTableData []string `envconfig:"POSTGRES_EXCLUDE_TABLE_DATA"`
+	PostgresNoOwner          bool     `envconfig:"POSTGRES_NO_OWNER"`
+
+	MysqlName      string `envconfig:"MYSQL_NAME"`
+	MysqlHost      string `envconfig:"MYSQL_HOST"`
+	MysqlDatabase  string `envconfig:"MYSQL_DATABASE"`
+	MysqlPassword  string `envconfig:"MYSQL_PASSWORD"`
+	MysqlUser      string `envconfig:"MYSQL_USER"`
+	MysqlAll       bool   `envconfig:"MYSQL_ALL_DATABASES"`
+	MysqlSocket    string `envconfig:"MYSQL_SOCKET"`
+	MysqlSslCa     string `envconfig:"MYSQL_SSL_CA"`
+	MysqlSslCert   string `envconfig:"MYSQL_SSL_CERT"`
+	MysqlSslKey    string `envconfig:"MYSQL_SSL_KEY"`
+	MysqlSslVerify bool   `envconfig:"MYSQL_SSL_VERIFY_SERVER_CERT"`
+	MysqlSsl       bool   `envconfig:"MYSQL_SSL"`
 
-	MysqlName     string `envconfig:"MYSQL_NAME"`
-	MysqlHost     string `envconfig:"MYSQL_HOST"`
-	MysqlDatabase string `envconfig:"MYSQL_DATABASE"`
-	MysqlPassword string `envconfig:"MYSQL_PASSWORD"`
-	MysqlUser     string `envconfig:"MYSQL_USER"`
+	MongodbName               string   `envconfig:"MONGODB_NAME"`
+	MongodbUri                string   `envconfig:"MONGODB_URI"`
+	MongodbAuthDatabase       string   `envconfig:"MONGODB_AUTH_DB"`
+	MongodbDatabase           string   `envconfig:"MONGODB_DB"`
+	MongodbCollections        []string `envconfig:"MONGODB_COLLECTIONS"`
+	MongodbExcludeCollections []string `envconfig:"MONGODB_EXCLUDE_COLLECTIONS"`
+	MongodbOplog              bool     `envconfig:"MONGODB_OPLOG"`
+
+	RedisName     string `envconfig:"REDIS_NAME"`
+	RedisHost     string `envconfig:"REDIS_HOST"`
+	RedisUser     string `envconfig:"REDIS_USER"`
+	RedisPassword string `envconfig:"REDIS_PASSWORD"`
+	RedisTls      bool   `envconfig:"REDIS_TLS"`
+	RedisCacert   string `envconfig:"REDIS_CACERT"`
+	RedisCert     string `envconfig:"REDIS_CERT"`
+	RedisKey      string `envconfig:"REDIS_KEY"`
+	RedisInsecure bool   `envconfig:"REDIS_INSECURE"`
 }
 
 // main contains basic handling, primarily parsing the command line
@@ -56,7 +193,8 @@ func main() {
 
 	// parse configuration (command-line)
 	b := BackupSet{}
-	parseCmdLine(&c, &b)
+	cr := cron.New()
+	parseCmdLine(&c, &b, cr)
 	// No Non-Debug output before this line
 
 	// start http server
@@ -77,16 +215,67 @@ func main() {
 	m.Register(nil)
 	b.SetMetrics(&m)
 
+	// repository statistics, before the export to include them
+	var stats *repositoryStats
+	if c.StatsSchedule != "" || c.StatsAfterBackup {
+		stats = NewRepositoryStats(&m)
+		if c.StatsSchedule != "" {
+			if err := cr.AddJob(c.StatsSchedule, stats); err != nil {
+				logger.Fatal("failed to schedule repository statistics", zap.Error(err))
+			}
+		}
+		if c.StatsAfterBackup {
+			b.AddNotifier(stats)
+		}
+	}
+
+	// compare volume snapshots with their predecessor
+	if c.DiffSummary {
+		var warnBytes int64
+		if c.DiffWarnBytes != "" {
+			var err error
+			if warnBytes, err = parseSize(c.DiffWarnBytes); err != nil {
+				logger.Fatal("invalid DIFF_WARN_BYTES", zap.Error(err))
+			}
+		}
+		b.AddNotifier(NewDiffReporter(warnBytes, c.DiffWarnFiles))
+	}
+
+	// export metrics after each run
+	if c.PushgatewayUrl != "" || c.MetricsTextfile != "" {
+		e := NewMetricsExporter(&m)
+		if c.PushgatewayUrl != "" {
+			host := c.Hostname
+			if host == "" {
+				host, _ = os.Hostname()
+			}
+			e.SetPushgateway(c.PushgatewayUrl, c.PushgatewayJob, map[string]string{"host": host, "set": c.SetName})
+		}
+		e.SetTextfile(c.MetricsTextfile)
+		b.AddNotifier(e)
+	}
+
 	logger.Debug("serving prometheus endpoint", zap.String("endpoint", c.PrometheusEndpoint))
 	http.Handle(c.PrometheusEndpoint, m.getHandler())
 
+	// run history, progress of running steps and events, served below
+	if c.HistorySize < 0 {
+		logger.Fatal("invalid HISTORY_SIZE", zap.Int("value", c.HistorySize))
+	}
+	history := NewRunHistory(c.HistorySize)
+	b.AddNotifier(history)
+	progress := NewBroker()
+	b.SetProgressBroker(progress)
+	events := NewBroker()
+	b.SetEventBroker(events)
+
 	// execute backup on startup
 	if c.RunOnStartup {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			logger.Debug("run backup on startup")
-			b.Run()
+			b.RunBy(TriggerStartup)
 		}()
 	}
 
@@ -94,7 +283,7 @@ func main() {
 	logger.Debug("serving ui endpoints")
 	// TODO: Move to separate handler object
 	http.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
-		err := b.Start()
+		err := b.StartBy(TriggerHttp)
 		if err != nil {
 			fmt.Fprintf(w, err.Error())
 		} else {
@@ -103,7 +292,7 @@ func main() {
 	})
 
 	http.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
-		b.Run()
+		b.RunBy(TriggerHttp)
 		// run does not return anything
 		// TODO: When there is a Wait() which returns the exit state use Start()/Wait() here
 		fmt.Fprintf(w, "done")
@@ -126,17 +315,30 @@ func main() {
 		}
 	})
 
-	// start cron scheduler
+	// json api and dashboard
+	api := &apiHandler{set: &b, stats: stats, history: history, progress: progress, events: events}
+	api.register()
+	http.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(uiFileSystem())))
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	})
+
+	// start cron scheduler, other jobs may have been added during configuration
 	if c.Schedule != "" {
+		err := cr.AddJob(c.Schedule, &b)
+		if err != nil {
+			logger.Fatal("failed to schedule task", zap.Error(err))
+		}
+	}
+	if len(cr.Entries()) > 0 {
 		wg.Add(1)
 		go func() {
-			cr := cron.New()
-			err := cr.AddJob(c.Schedule, &b)
-			if err != nil {
-				logger.Fatal("failed to schedule task", zap.Error(err))
-			}
 			cr.Run()
-			logger.Fatal("cron scheduler terminated", zap.Error(err))
+			logger.Fatal("cron scheduler terminated")
 		}()
 	}
 
@@ -145,12 +347,14 @@ func main() {
 	wg.Wait()
 }
 
-func parseCmdLine(c *config, b *BackupSet) {
+func parseCmdLine(c *config, b *BackupSet, cr *cron.Cron) {
 	var volumes []string
+	var sqlites []string
 
 	help := getopt.BoolLong("help", '?', "print usage")
 	getopt.FlagLong(&c.Hostname, "host", 'h', "set the hostname for restic snapshots")
 	getopt.FlagLong(&volumes, "volume", 'v', "path to a volume to save, may added multiple times", "/data/path")
+	getopt.FlagLong(&sqlites, "sqlite", 0, "path to a sqlite database or a directory to search for databases, may added multiple times", "/data/path/app.db")
 	getopt.FlagLong(&c.RunOnStartup, "run", 'r', "run on startup")
 	getopt.FlagLong(&c.Schedule, "schedule", 's', "add cron schedule")
 	getopt.FlagLong(&c.ListenAddress, "listen-host", 'l', "set listen address for http server")
@@ -164,33 +368,258 @@ func parseCmdLine(c *config, b *BackupSet) {
 
 	b.SetRepository(c.Repository, c.Password)
 	b.SetHostname(c.Hostname)
+	b.SetName(c.SetName)
+	b.SetHooks(c.hooks(c.PreHook, c.PostHook))
+
+	// before the steps are added, they get a copy of the destination
+	if c.LimitUpload > 0 || c.LimitDownload > 0 || len(c.LimitUploadWindows) > 0 || len(c.LimitDownloadWindows) > 0 {
+		limits := &bandwidthLimits{upload: c.LimitUpload, download: c.LimitDownload}
+		var err error
+		if limits.uploadRules, err = parseLimitRules(c.LimitUploadWindows); err != nil {
+			logger.Fatal("invalid LIMIT_UPLOAD_WINDOWS", zap.Error(err))
+		}
+		if limits.downloadRules, err = parseLimitRules(c.LimitDownloadWindows); err != nil {
+			logger.Fatal("invalid LIMIT_DOWNLOAD_WINDOWS", zap.Error(err))
+		}
+		b.SetBandwidthLimits(limits)
+	}
+	if len(c.BlackoutWindows) > 0 {
+		windows, err := parseTimeWindows(c.BlackoutWindows)
+		if err != nil {
+			logger.Fatal("invalid BLACKOUT_WINDOWS", zap.Error(err))
+		}
+		b.SetBlackoutWindows(windows)
+	}
+	if c.MaxParallel < 0 {
+		logger.Fatal("invalid MAX_PARALLEL", zap.Int("value", c.MaxParallel))
+	}
+	b.SetMaxParallel(c.MaxParallel)
+	if c.Sequential {
+		b.SetMaxParallel(1)
+	}
+
+	if c.AnomalyFilesFactor > 0 || c.AnomalyBytesFactor > 0 {
+		minBytes, err := parseSize(c.AnomalyMinBytes)
+		if err != nil {
+			logger.Fatal("invalid ANOMALY_MIN_BYTES", zap.Error(err))
+		}
+		if c.AnomalyWindow < 3 {
+			logger.Fatal("invalid ANOMALY_WINDOW, at least 3 snapshots are required", zap.Int("value", c.AnomalyWindow))
+		}
+		d := NewAnomalyDetector(c.AnomalyWindow, c.AnomalyFilesFactor, c.AnomalyBytesFactor)
+		d.SetMinimum(c.AnomalyMinFiles, minBytes)
+		b.SetAnomalyDetector(d)
+		b.SetAnomalyBlocksForget(c.AnomalyBlockForget)
+	}
+
+	// Add notifiers
+	for _, u := range c.WebhookUrls {
+		n, err := NewWebhookNotifier(u, c.WebhookFormat)
+		if err != nil {
+			logger.Fatal("Failed to add webhook", zap.Error(err))
+		}
+		n.SetOnFailureOnly(c.WebhookOnFailureOnly)
+		n.SetRetries(c.WebhookRetries, 10*time.Second)
+		b.AddNotifier(n)
+	}
+	if c.PingUrl != "" {
+		n, err := NewPingNotifier(c.PingUrl)
+		if err != nil {
+			logger.Fatal("Failed to add ping notifier", zap.Error(err))
+		}
+		b.AddNotifier(n)
+	}
+	if c.SmtpHost != "" {
+		n, err := NewEmailNotifier(c.SmtpHost, c.SmtpPort, c.SmtpSecurity, c.SmtpFrom, c.SmtpTo)
+		if err != nil {
+			logger.Fatal("Failed to add email notifier", zap.Error(err))
+		}
+		n.SetAuth(c.SmtpUsername, c.SmtpPassword)
+		n.SetOnFailureOnly(c.SmtpOnFailureOnly)
+		if err = n.SetTemplates(c.SmtpTemplateText, c.SmtpTemplateHtml); err != nil {
+			logger.Fatal("Failed to load email templates", zap.Error(err))
+		}
+		if c.SmtpDigestSchedule != "" {
+			n.SetDigest(true)
+			if err = cr.AddJob(c.SmtpDigestSchedule, n); err != nil {
+				logger.Fatal("Failed to schedule email digest", zap.Error(err))
+			}
+		}
+		b.AddNotifier(n)
+	}
+
+	// Options of all volumes, extended per volume
+	_, volumeOptions, err := ParseVolume(";"+c.VolumeOptions, VolumeOptions{Priority: c.VolumePriority, After: c.VolumeAfter})
+	if err != nil {
+		logger.Fatal("invalid VOLUME_OPTIONS", zap.Error(err))
+	}
+
+	var docker *dockerClient
+	if c.DockerDiscovery || c.ContainerAction != "" {
+		var err error
+		docker, err = NewDockerClient(c.DockerHost)
+		if err != nil {
+			logger.Fatal("Failed to configure docker client", zap.Error(err))
+		}
+	}
+
+	// Discover steps by container labels at each run
+	if c.DockerDiscovery {
+		d := NewDockerDiscovery(docker, c.DockerLabelPrefix)
+		d.SetHookTimeout(c.HookTimeout)
+		d.SetTags(c.Tags)
+		d.SetVolumeOptions(volumeOptions)
+		b.SetDiscovery(d)
+	}
+
+	// Pause or stop containers during volume backups
+	var action *containerAction
+	if c.ContainerAction != "" {
+		var err error
+		action, err = NewContainerAction(docker, c.ContainerAction, c.ContainerNames, c.ContainerLabel)
+		if err != nil {
+			logger.Fatal("Failed to configure container action", zap.Error(err))
+		}
+		action.SetStopTimeout(c.ContainerStopTimeout)
+		action.SetTimeout(c.ContainerTimeout)
+	}
 
 	// Add volume steps
 	for _, v := range volumes {
-		b.AddStep(NewVolumeStep(v))
+		path, options, err := ParseVolume(v, volumeOptions)
+		if err != nil {
+			logger.Fatal("invalid volume", zap.String("volume", v), zap.Error(err))
+		}
+		s := NewVolumeStep(path)
+		s.SetOptions(options)
+		if action != nil {
+			s.SetContainerAction(action)
+		}
+		s.SetHooks(c.hooks(c.VolumePreHook, c.VolumePostHook))
+		s.SetTags(c.tags(c.VolumeTags))
+		b.AddStep(s)
+	}
+
+	// Add sqlite steps
+	for _, v := range sqlites {
+		s := NewSqliteStep(v)
+		s.SetHooks(c.hooks(c.SqlitePreHook, c.SqlitePostHook))
+		s.SetTags(c.tags(c.SqliteTags))
+		s.SetOrder(c.SqlitePriority, c.SqliteAfter)
+		b.AddStep(s)
 	}
 
 	// Add database steps by environment variables - PostgreSQL
 	if c.PostgresHost != "" {
-		s, err := NewPostgresStep(c.PostgresHost, c.PostgresUser, c.PostgresPassword, c.PostgresDatabase)
+		database := c.PostgresDatabase
+		if c.PostgresAll {
+			database = ""
+		}
+		s, err := NewPostgresStep(c.PostgresHost, c.PostgresUser, c.PostgresPassword, database)
+		if err != nil {
+			logger.Fatal("Failed to add postgres step", zap.Error(err))
+		}
+		s.SetTls(c.PostgresSslMode, c.PostgresSslCert, c.PostgresSslKey, c.PostgresSslRootCert)
+		s.SetAllDatabases(c.PostgresAll)
+		err = s.SetDumpOptions(postgresDumpOptions{
+			Format:           c.PostgresFormat,
+			Compress:         c.PostgresCompress,
+			Jobs:             c.PostgresJobs,
+			Directory:        c.PostgresDumpDir,
+			Schemas:          c.PostgresSchemas,
+			ExcludeTables:    c.PostgresExcludeTable,
+			ExcludeTableData: c.PostgresExcludeTableData,
+			NoOwner:          c.PostgresNoOwner,
+		})
 		if err != nil {
 			logger.Fatal("Failed to add postgres step", zap.Error(err))
 		}
 		if c.PostgresName != "" {
 			s.SetName(c.PostgresName)
 		}
+		s.SetHooks(c.hooks(c.PostgresPreHook, c.PostgresPostHook))
+		s.SetTags(c.tags(c.PostgresTags))
+		s.SetOrder(c.PostgresPriority, c.PostgresAfter)
 		b.AddStep(s)
 	}
 
 	// Add database steps by environment variables - MariaDB
-	if c.MysqlHost != "" {
+	if c.MysqlHost != "" || c.MysqlSocket != "" {
 		s, err := NewMariadbStep(c.MysqlHost, c.MysqlUser, c.MysqlPassword, c.MysqlDatabase)
 		if err != nil {
 			logger.Fatal("Failed to add mariadb step", zap.Error(err))
 		}
+		if c.MysqlSocket != "" {
+			s.SetSocket(c.MysqlSocket)
+		}
+		if c.MysqlSslVerify && !c.MysqlSsl {
+			logger.Fatal("MYSQL_SSL_VERIFY_SERVER_CERT requires MYSQL_SSL")
+		}
+		if c.MysqlSsl {
+			s.SetTls(c.MysqlSslCa, c.MysqlSslCert, c.MysqlSslKey, c.MysqlSslVerify)
+		}
+		s.SetAllDatabases(c.MysqlAll)
 		if c.MysqlName != "" {
 			s.SetName(c.MysqlName)
 		}
+		s.SetHooks(c.hooks(c.MysqlPreHook, c.MysqlPostHook))
+		s.SetTags(c.tags(c.MysqlTags))
+		s.SetOrder(c.MysqlPriority, c.MysqlAfter)
+		b.AddStep(s)
+	}
+
+	// Add database steps by environment variables - MongoDB
+	if c.MongodbUri != "" {
+		s, err := NewMongodbStep(c.MongodbUri, c.MongodbAuthDatabase, c.MongodbDatabase)
+		if err != nil {
+			logger.Fatal("Failed to add mongodb step", zap.Error(err))
+		}
+		if c.MongodbName != "" {
+			s.SetName(c.MongodbName)
+		}
+		s.SetCollections(c.MongodbCollections, c.MongodbExcludeCollections)
+		if err = s.SetOplog(c.MongodbOplog); err != nil {
+			logger.Fatal("Failed to add mongodb step", zap.Error(err))
+		}
+		s.SetHooks(c.hooks(c.MongodbPreHook, c.MongodbPostHook))
+		s.SetTags(c.tags(c.MongodbTags))
+		s.SetOrder(c.MongodbPriority, c.MongodbAfter)
 		b.AddStep(s)
 	}
+
+	// Add database steps by environment variables - Redis
+	if c.RedisHost != "" {
+		s, err := NewRedisStep(c.RedisHost, c.RedisUser, c.RedisPassword)
+		if err != nil {
+			logger.Fatal("Failed to add redis step", zap.Error(err))
+		}
+		if c.RedisName != "" {
+			s.SetName(c.RedisName)
+		}
+		if c.RedisTls {
+			s.SetTls(c.RedisCacert, c.RedisCert, c.RedisKey, c.RedisInsecure)
+		}
+		s.SetHooks(c.hooks(c.RedisPreHook, c.RedisPostHook))
+		s.SetTags(c.tags(c.RedisTags))
+		s.SetOrder(c.RedisPriority, c.RedisAfter)
+		b.AddStep(s)
+	}
+
+	// Discovered steps can only be checked at each run
+	if err := b.ValidateOrder(); err != nil {
+		logger.Fatal("invalid step order", zap.Error(err))
+	}
+}
+
+// tags returns the tags for all steps followed by the tags of a step type
+func (c *config) tags(typeTags []string) []string {
+	return append(append([]string{}, c.Tags...), typeTags...)
+}
+
+// hooks creates pre and post hooks with the configured timeout
+func (c *config) hooks(pre string, post string) StepHooks {
+	return StepHooks{
+		Pre:  NewHook(pre, c.HookTimeout),
+		Post: NewHook(post, c.HookTimeout),
+	}
 }
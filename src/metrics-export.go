@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// metricsExporter pushes the metrics to a Pushgateway and/or writes them
+// to a node_exporter textfile collector file after each run. In one-shot
+// usage the /metrics endpoint is gone before it is scraped.
+type metricsExporter struct {
+	gatherer prometheus.Gatherer
+	pushUrl  string
+	job      string
+	grouping map[string]string
+	textfile string
+	client   *http.Client
+}
+
+func NewMetricsExporter(m *MetricsCollection) *metricsExporter {
+	e := &metricsExporter{}
+	e.gatherer = m.Gatherer()
+	e.grouping = map[string]string{}
+	e.client = &http.Client{Timeout: 30 * time.Second}
+
+	return e
+}
+
+// SetPushgateway enables pushing, grouping labels are e.g. host and set
+func (e *metricsExporter) SetPushgateway(url string, job string, grouping map[string]string) {
+	e.pushUrl = url
+	e.job = job
+	e.grouping = grouping
+}
+
+// SetTextfile enables writing the metrics in textfile collector format
+func (e *metricsExporter) SetTextfile(filename string) {
+	e.textfile = filename
+}
+
+func (e *metricsExporter) Name() string {
+	return "metrics"
+}
+
+func (e *metricsExporter) Notify(r *RunReport) error {
+	if e.textfile != "" {
+		// written to a temporary file and renamed, so it is never read half-written
+		if err := prometheus.WriteToTextfile(e.textfile, e.gatherer); err != nil {
+			return err
+		}
+	}
+
+	if e.pushUrl != "" {
+		p := push.New(e.pushUrl, e.job).Client(e.client).Gatherer(e.gatherer)
+		for name, value := range e.grouping {
+			p = p.Grouping(name, value)
+		}
+		if err := p.Push(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -9,15 +10,23 @@ import (
 
 type MetricsCollection struct {
 	registerer prometheus.Registerer
+	registry   *prometheus.Registry // agent metrics only, for pushing and writing
 
 	// global statistics
 	BackupsTotal      prometheus.Counter
 	BackupsSuccessful prometheus.Counter
 	BackupsFailed     prometheus.Counter
 
-	// repository statistics
-	DataBlobs prometheus.Gauge
-	TreeBlobs prometheus.Gauge
+	// repository statistics, see repositoryStats
+	DataBlobs                  prometheus.Gauge
+	TreeBlobs                  prometheus.Gauge
+	RepositoryBlobs            prometheus.Gauge
+	RepositorySize             prometheus.Gauge
+	RepositoryUncompressedSize prometheus.Gauge
+	RepositoryRestoreSize      prometheus.Gauge
+	SnapshotCount              *prometheus.GaugeVec
+	SnapshotOldestTime         *prometheus.GaugeVec
+	SnapshotNewestTime         *prometheus.GaugeVec
 
 	// snapshot statistics
 	FilesNew        prometheus.Gauge
@@ -30,6 +39,19 @@ type MetricsCollection struct {
 	BytesProcessed  prometheus.Gauge // `total_bytes_processed` in summary, `total_bytes` in status messages
 	BytesAdded      prometheus.Gauge
 	BackupDuration  prometheus.Gauge // `total_duration` in summary message
+
+	// container statistics
+	ContainerDowntime prometheus.Gauge
+
+	// anomaly detection
+	Anomalies      *prometheus.GaugeVec
+	AnomaliesTotal prometheus.Counter
+
+	// progress of running steps
+	ProgressRatio            *prometheus.GaugeVec
+	ProgressBytesDone        *prometheus.GaugeVec
+	ProgressBytesTotal       *prometheus.GaugeVec
+	ProgressSecondsRemaining *prometheus.GaugeVec
 }
 
 func (m *MetricsCollection) Initialize() {
@@ -63,6 +85,42 @@ func (m *MetricsCollection) Initialize() {
 		Help:      "The number of tree blobs in the repository.",
 	})
 
+	m.RepositoryBlobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "repository_blobs",
+		Help:      "The number of blobs in the repository.",
+	})
+	m.RepositorySize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "repository_size_bytes",
+		Help:      "The deduplicated size of all data stored in the repository.",
+	})
+	m.RepositoryUncompressedSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "repository_uncompressed_size_bytes",
+		Help:      "The deduplicated size of all data in the repository before compression.",
+	})
+	m.RepositoryRestoreSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "repository_restore_size_bytes",
+		Help:      "The size of all snapshots when restored, without deduplication.",
+	})
+	m.SnapshotCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "snapshots",
+		Help:      "The number of snapshots per host and path.",
+	}, []string{"host", "path"})
+	m.SnapshotOldestTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "snapshot_oldest_timestamp_seconds",
+		Help:      "The time of the oldest snapshot per host and path as unix timestamp.",
+	}, []string{"host", "path"})
+	m.SnapshotNewestTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "snapshot_newest_timestamp_seconds",
+		Help:      "The time of the newest snapshot per host and path as unix timestamp.",
+	}, []string{"host", "path"})
+
 	// snapshot statistics
 	m.FilesNew = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "backup",
@@ -114,6 +172,47 @@ func (m *MetricsCollection) Initialize() {
 		Name:      "restic_duration_milliseconds",
 		Help:      "The duration of backups in milliseconds.",
 	})
+
+	// containers paused or stopped during volume backups
+	m.ContainerDowntime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "container_downtime_milliseconds",
+		Help:      "The time containers were paused or stopped during the last volume backup in milliseconds.",
+	})
+
+	// anomalies of the last run, reset at the beginning of each run
+	m.Anomalies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "anomaly",
+		Help:      "1 if the last snapshot of a step changed much more than usual.",
+	}, []string{"step", "type", "description", "metric"})
+	m.AnomaliesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "backup",
+		Name:      "anomalies_total",
+		Help:      "The total number of anomalies detected.",
+	})
+
+	// progress per step, reset at the beginning of each run
+	m.ProgressRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "progress_ratio",
+		Help:      "The progress of the running or last backup per step, between 0 and 1.",
+	}, []string{"step", "type", "description"})
+	m.ProgressBytesDone = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "progress_bytes_done",
+		Help:      "The bytes processed by the running or last backup per step.",
+	}, []string{"step", "type", "description"})
+	m.ProgressBytesTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "progress_bytes_total",
+		Help:      "The bytes to be processed by the running or last backup per step, 0 if unknown.",
+	}, []string{"step", "type", "description"})
+	m.ProgressSecondsRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "backup",
+		Name:      "progress_seconds_remaining",
+		Help:      "The estimated remaining time of the running backup per step.",
+	}, []string{"step", "type", "description"})
 }
 
 func (m *MetricsCollection) Register(r prometheus.Registerer) {
@@ -122,12 +221,27 @@ func (m *MetricsCollection) Register(r prometheus.Registerer) {
 	}
 	m.registerer = r
 
-	r.MustRegister(
+	r.MustRegister(m.collectors()...)
+
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(m.collectors()...)
+}
+
+// collectors returns all metrics of the collection
+func (m *MetricsCollection) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
 		m.BackupsTotal,
 		m.BackupsSuccessful,
 		m.BackupsFailed,
 		m.DataBlobs,
 		m.TreeBlobs,
+		m.RepositoryBlobs,
+		m.RepositorySize,
+		m.RepositoryUncompressedSize,
+		m.RepositoryRestoreSize,
+		m.SnapshotCount,
+		m.SnapshotOldestTime,
+		m.SnapshotNewestTime,
 		m.FilesNew,
 		m.FilesChanged,
 		m.FilesUnmodified,
@@ -138,7 +252,33 @@ func (m *MetricsCollection) Register(r prometheus.Registerer) {
 		m.BytesProcessed,
 		m.BytesAdded,
 		m.BackupDuration,
-	)
+		m.ContainerDowntime,
+		m.Anomalies,
+		m.AnomaliesTotal,
+		m.ProgressRatio,
+		m.ProgressBytesDone,
+		m.ProgressBytesTotal,
+		m.ProgressSecondsRemaining,
+	}
+}
+
+// Gatherer returns the agent metrics without go and process metrics
+func (m *MetricsCollection) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// SetSummary updates the snapshot statistics with the summary of the last snapshot
+func (m *MetricsCollection) SetSummary(s ResticSummary) {
+	m.FilesNew.Set(float64(s.FilesNew))
+	m.FilesChanged.Set(float64(s.FilesChanged))
+	m.FilesUnmodified.Set(float64(s.FilesUnmodified))
+	m.DirsNew.Set(float64(s.DirsNew))
+	m.DirsChanged.Set(float64(s.DirsChanged))
+	m.DirsUnmodified.Set(float64(s.DirsUnmodified))
+	m.FilesProcessed.Set(float64(s.TotalFilesProcessed))
+	m.BytesProcessed.Set(float64(s.TotalBytesProcessed))
+	m.BytesAdded.Set(float64(s.DataAdded))
+	m.BackupDuration.Set(s.TotalDuration * 1000)
 }
 
 func (m *MetricsCollection) getHandler() http.Handler {
@@ -151,3 +291,20 @@ func (m *MetricsCollection) getHandler() http.Handler {
 		m.registerer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{}),
 	)
 }
+
+// SetProgress updates the progress gauges of a step
+func (m *MetricsCollection) SetProgress(p StepProgress) {
+	labels := prometheus.Labels{"step": strconv.Itoa(p.Index), "type": p.Type, "description": p.Description}
+	m.ProgressRatio.With(labels).Set(p.PercentDone)
+	m.ProgressBytesDone.With(labels).Set(float64(p.BytesDone))
+	m.ProgressBytesTotal.With(labels).Set(float64(p.TotalBytes))
+	m.ProgressSecondsRemaining.With(labels).Set(float64(p.SecondsRemaining))
+}
+
+// ResetProgress removes the progress of all steps
+func (m *MetricsCollection) ResetProgress() {
+	m.ProgressRatio.Reset()
+	m.ProgressBytesDone.Reset()
+	m.ProgressBytesTotal.Reset()
+	m.ProgressSecondsRemaining.Reset()
+}
@@ -37,7 +37,7 @@ func (v *safeBool) SetIf(newValue bool, oldValue bool) bool {
 	return false
 }
 
-/* thread-safe ~int~ type
+// thread-safe ~int~ type
 type safeInt struct {
 	mu    sync.Mutex
 	value int
@@ -78,4 +78,3 @@ func (v *safeInt) SetIf(newValue int, oldValue int) bool {
 
 	return false
 }
-*/
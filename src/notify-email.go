@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Time limit for connecting to the mail server and for the whole dialog
+const (
+	emailDialTimeout = 10 * time.Second
+	emailTimeout     = 30 * time.Second
+)
+
+// Default templates, both get an emailData value
+const (
+	emailTextTemplate = `{{ .Title }}
+
+{{ range .Reports -}}
+{{ .Title }} ({{ .Started.Format "2006-01-02 15:04:05" }})
+{{ .Text }}
+{{ end -}}
+{{ if .Digest }}{{ len .Reports }} runs, {{ .Failed }} failed, {{ bytes .BytesAdded }} added to the repository
+{{ end }}`
+
+	emailHtmlTemplate = `<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{ .Title }}</h2>
+{{ if .Digest }}<p>{{ len .Reports }} runs, {{ .Failed }} failed, {{ bytes .BytesAdded }} added to the repository</p>{{ end }}
+{{ range .Reports }}
+<h3 style="color: {{ if .Quiet }}#2eb886{{ else }}#d00000{{ end }}">{{ .Title }}</h3>
+<p>{{ .Started.Format "2006-01-02 15:04:05" }}, duration {{ duration .Started .Finished }}, {{ bytes .BytesAdded }} added{{ if .Error }}<br>Error: {{ .Error }}{{ end }}</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Step</th><th>Status</th><th>Snapshots</th><th>Added</th></tr>
+{{ range .Steps }}<tr><td>{{ .Type }} {{ .Description }}</td><td>{{ .Status }}{{ if .Error }}: {{ .Error }}{{ end }}</td>
+<td>{{ range .Snapshots }}{{ .SnapshotId }} {{ end }}</td><td>{{ range .Snapshots }}{{ bytes .DataAdded }} {{ end }}</td></tr>
+{{ end }}</table>
+{{ end }}
+</body></html>`
+)
+
+var emailTemplateFuncs = map[string]interface{}{
+	"bytes": formatBytes,
+	"duration": func(start time.Time, end time.Time) string {
+		return end.Sub(start).Round(time.Second).String()
+	},
+}
+
+// emailData is passed to the templates, a single report is sent as a
+// digest with one run.
+type emailData struct {
+	Title      string
+	Digest     bool
+	Reports    []*RunReport
+	Failed     int
+	BytesAdded int64
+}
+
+type emailNotifier struct {
+	host          string
+	port          int
+	security      string // "starttls", "tls" or "none"
+	username      string
+	password      string
+	from          string
+	to            []string
+	onFailureOnly bool
+	digest        bool
+	text          *template.Template
+	html          *htmltemplate.Template
+
+	// reports collected for the digest
+	mu      sync.Mutex
+	reports []*RunReport
+}
+
+func NewEmailNotifier(host string, port int, security string, from string, to []string) (*emailNotifier, error) {
+	if host == "" || from == "" || len(to) == 0 {
+		return nil, errors.New("smtp host, sender and recipients are required")
+	}
+	switch security {
+	case "starttls", "tls", "none":
+	default:
+		return nil, errors.New("unknown smtp security: " + security)
+	}
+
+	n := &emailNotifier{}
+	n.host = host
+	n.port = port
+	n.security = security
+	n.from = from
+	n.to = to
+	n.text = template.Must(template.New("text").Funcs(emailTemplateFuncs).Parse(emailTextTemplate))
+	n.html = htmltemplate.Must(htmltemplate.New("html").Funcs(emailTemplateFuncs).Parse(emailHtmlTemplate))
+
+	return n, nil
+}
+
+func (n *emailNotifier) SetAuth(username string, password string) {
+	n.username = username
+	n.password = password
+}
+
+// SetOnFailureOnly suppresses mails of successful runs, digests are always sent
+func (n *emailNotifier) SetOnFailureOnly(onFailureOnly bool) {
+	n.onFailureOnly = onFailureOnly
+}
+
+// SetDigest collects the reports instead of sending them, see SendDigest
+func (n *emailNotifier) SetDigest(digest bool) {
+	n.digest = digest
+}
+
+// SetTemplates overrides the default templates by files, empty names keep the default
+func (n *emailNotifier) SetTemplates(textFile string, htmlFile string) error {
+	if textFile != "" {
+		content, err := ioutil.ReadFile(textFile)
+		if err != nil {
+			return err
+		}
+		if n.text, err = template.New("text").Funcs(emailTemplateFuncs).Parse(string(content)); err != nil {
+			return err
+		}
+	}
+	if htmlFile != "" {
+		content, err := ioutil.ReadFile(htmlFile)
+		if err != nil {
+			return err
+		}
+		if n.html, err = htmltemplate.New("html").Funcs(emailTemplateFuncs).Parse(string(content)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *emailNotifier) Name() string {
+	return "email"
+}
+
+func (n *emailNotifier) Notify(r *RunReport) error {
+	if n.digest {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		n.reports = append(n.reports, r)
+		return nil
+	}
+	if n.onFailureOnly && r.Quiet() {
+		return nil
+	}
+
+	return n.send(emailData{Title: r.Title(), Reports: []*RunReport{r}, Failed: r.FailedSteps(), BytesAdded: r.BytesAdded()})
+}
+
+// SendDigest sends a summary of all runs since the last digest
+func (n *emailNotifier) SendDigest() error {
+	n.mu.Lock()
+	reports := n.reports
+	n.reports = nil
+	n.mu.Unlock()
+
+	data := emailData{Digest: true, Reports: reports}
+	for _, r := range reports {
+		if r.Status != "success" {
+			data.Failed++
+		}
+		data.BytesAdded += r.BytesAdded()
+	}
+	switch {
+	case len(reports) == 0:
+		data.Title = "Backup digest: no backup runs"
+	case data.Failed > 0:
+		data.Title = "Backup digest: " + strconv.Itoa(data.Failed) + " of " + strconv.Itoa(len(reports)) + " runs failed"
+	default:
+		data.Title = "Backup digest: " + strconv.Itoa(len(reports)) + " runs succeeded"
+	}
+
+	err := n.send(data)
+	if err != nil {
+		// keep the reports for the next digest
+		n.mu.Lock()
+		n.reports = append(reports, n.reports...)
+		n.mu.Unlock()
+	}
+
+	return err
+}
+
+// Run implements cron.Job for the digest schedule
+func (n *emailNotifier) Run() {
+	if err := n.SendDigest(); err != nil {
+		logger.Error("failed to send digest", zap.Error(err))
+		return
+	}
+	logger.Info("digest sent")
+}
+
+func (n *emailNotifier) send(data emailData) error {
+	message, err := n.message(data)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(n.host, strconv.Itoa(n.port))
+	dialer := &net.Dialer{Timeout: emailDialTimeout}
+	var conn net.Conn
+	if n.security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: n.host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// A stalled server must not block the notifiers forever
+	if err = conn.SetDeadline(time.Now().Add(emailTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.security == "starttls" {
+		if err = client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+	if n.username != "" {
+		if err = client.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(message); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// message renders a multipart/alternative mail with text and html part
+func (n *emailNotifier) message(data emailData) ([]byte, error) {
+	text := bytes.NewBuffer(nil)
+	if err := n.text.Execute(text, data); err != nil {
+		return nil, err
+	}
+	html := bytes.NewBuffer(nil)
+	if err := n.html.Execute(html, data); err != nil {
+		return nil, err
+	}
+
+	body := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	mw.Close()
+
+	header := bytes.NewBuffer(nil)
+	header.WriteString("From: " + n.from + "\r\n")
+	header.WriteString("To: " + strings.Join(n.to, ", ") + "\r\n")
+	header.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", data.Title) + "\r\n")
+	header.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	header.WriteString("MIME-Version: 1.0\r\n")
+	header.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n")
+	header.WriteString("\r\n")
+
+	return append(header.Bytes(), body.Bytes()...), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum size of the log tail sent with the final ping
+const pingBodyLimit = 10000
+
+// pingNotifier pings a dead man's switch like healthchecks.io:
+// "<url>/start" when a run begins and "<url>/<exit code>" when it is done,
+// where exit code 0 signals success. Missing or late pings are detected by
+// the monitoring service, even if the agent itself is dead.
+type pingNotifier struct {
+	url    string
+	client *http.Client
+	start  int // log tail position when the run began
+}
+
+func NewPingNotifier(url string) (*pingNotifier, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("ping url must start with http:// or https://")
+	}
+
+	n := &pingNotifier{}
+	n.url = strings.TrimRight(url, "/")
+	n.client = &http.Client{Timeout: 10 * time.Second}
+
+	return n, nil
+}
+
+func (n *pingNotifier) Name() string {
+	return "ping"
+}
+
+// NotifyStart is called when a run begins, the service measures the duration
+func (n *pingNotifier) NotifyStart() error {
+	n.start = logTail.Position()
+	return n.ping("/start", "")
+}
+
+// Notify sends the exit code (number of failed steps) along with the report
+// and the log lines of the run
+func (n *pingNotifier) Notify(r *RunReport) error {
+	code := 0
+	if r.Status != "success" {
+		code = r.FailedSteps()
+		if code == 0 {
+			code = 1
+		}
+		if code > 255 {
+			code = 255
+		}
+	}
+
+	body := r.Title() + "\n" + r.Text()
+	if code != 0 {
+		// The report is kept, the oldest log lines are cut
+		tail := logTail.Since(n.start)
+		room := pingBodyLimit - len(body) - 1
+		if room < 0 {
+			room = 0
+		}
+		if len(tail) > room {
+			tail = tail[len(tail)-room:]
+			if i := strings.IndexByte(tail, '\n'); i >= 0 {
+				tail = tail[i+1:]
+			}
+		}
+		body += "\n" + tail
+	}
+	if len(body) > pingBodyLimit {
+		body = body[:pingBodyLimit]
+	}
+
+	return n.ping("/"+strconv.Itoa(code), body)
+}
+
+func (n *pingNotifier) ping(path string, body string) error {
+	resp, err := n.client.Post(n.url+path, "text/plain", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return errors.New("ping returned " + resp.Status)
+	}
+
+	return nil
+}
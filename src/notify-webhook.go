@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Payload formats of webhooks, the format may be prefixed to the url as
+// in "slack+https://hooks.slack.com/services/..."
+var webhookFormats = []string{"json", "slack", "mattermost", "teams", "ntfy"}
+
+type webhookNotifier struct {
+	url           string
+	format        string
+	onFailureOnly bool
+	retries       int
+	retryDelay    time.Duration
+	client        *http.Client
+}
+
+func NewWebhookNotifier(url string, format string) (*webhookNotifier, error) {
+	n := &webhookNotifier{}
+	n.url = url
+	n.format = format
+	n.retries = 3
+	n.retryDelay = 10 * time.Second
+	n.client = &http.Client{Timeout: 30 * time.Second}
+
+	for _, f := range webhookFormats {
+		if strings.HasPrefix(url, f+"+") {
+			n.format = f
+			n.url = strings.TrimPrefix(url, f+"+")
+		}
+	}
+	if n.format == "" {
+		n.format = "json"
+	}
+	if !isWebhookFormat(n.format) {
+		return nil, errors.New("unknown webhook format: " + n.format)
+	}
+	if !strings.HasPrefix(n.url, "http://") && !strings.HasPrefix(n.url, "https://") {
+		return nil, errors.New("webhook url must start with http:// or https://")
+	}
+
+	return n, nil
+}
+
+// SetOnFailureOnly suppresses notifications of successful runs
+func (n *webhookNotifier) SetOnFailureOnly(onFailureOnly bool) {
+	n.onFailureOnly = onFailureOnly
+}
+
+// SetRetries sets the number of additional delivery attempts
+func (n *webhookNotifier) SetRetries(retries int, delay time.Duration) {
+	n.retries = retries
+	n.retryDelay = delay
+}
+
+// Name is used for logging and must not contain the url, it may contain credentials
+func (n *webhookNotifier) Name() string {
+	return "webhook/" + n.format
+}
+
+func (n *webhookNotifier) Notify(r *RunReport) (err error) {
+	if n.onFailureOnly && r.Quiet() {
+		return nil
+	}
+
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			logger.Warn("webhook delivery failed, retrying", zap.String("notifier", n.Name()), zap.Int("attempt", attempt), zap.Error(err))
+			time.Sleep(n.retryDelay)
+		}
+		if err = n.send(r); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (n *webhookNotifier) send(r *RunReport) error {
+	req, err := n.request(r)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return errors.New("webhook returned " + resp.Status)
+	}
+
+	return nil
+}
+
+// request creates the http request in the configured format
+func (n *webhookNotifier) request(r *RunReport) (*http.Request, error) {
+	var body []byte
+	var err error
+	contentType := "application/json"
+
+	switch n.format {
+	case "json":
+		body, err = json.Marshal(r)
+	case "slack", "mattermost":
+		body, err = json.Marshal(map[string]string{"text": "*" + r.Title() + "*\n" + r.Text()})
+	case "teams":
+		// legacy MessageCard, accepted by incoming webhooks and workflows
+		color := "2EB886"
+		if !r.Quiet() {
+			color = "D00000"
+		}
+		body, err = json.Marshal(map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    r.Title(),
+			"title":      r.Title(),
+			"themeColor": color,
+			"text":       strings.ReplaceAll(r.Text(), "\n", "<br>"),
+		})
+	case "ntfy":
+		body = []byte(r.Text())
+		contentType = "text/plain"
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if n.format == "ntfy" {
+		req.Header.Set("Title", r.Title())
+		if r.Quiet() {
+			req.Header.Set("Tags", "white_check_mark")
+		} else {
+			req.Header.Set("Tags", "warning")
+			req.Header.Set("Priority", "high")
+		}
+	}
+
+	return req, nil
+}
+
+func isWebhookFormat(format string) bool {
+	for _, f := range webhookFormats {
+		if format == f {
+			return true
+		}
+	}
+
+	return false
+}
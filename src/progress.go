@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// ResticStatus is the status message of "restic backup --json"
+type ResticStatus struct {
+	PercentDone      float64  `json:"percent_done"`
+	TotalFiles       int64    `json:"total_files"`
+	FilesDone        int64    `json:"files_done"`
+	TotalBytes       int64    `json:"total_bytes"`
+	BytesDone        int64    `json:"bytes_done"`
+	ErrorCount       int64    `json:"error_count"`
+	SecondsElapsed   int64    `json:"seconds_elapsed"`
+	SecondsRemaining int64    `json:"seconds_remaining"`
+	CurrentFiles     []string `json:"current_files,omitempty"`
+}
+
+// StepProgress is the last status of a running step, index, type and
+// description are set by the backup set.
+type StepProgress struct {
+	Index       int       `json:"index"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Name        string    `json:"name"` // path or stdin filename
+	Updated     time.Time `json:"updated"`
+	ResticStatus
+}
+
+// ProgressStep is implemented by steps which report the progress of restic
+type ProgressStep interface {
+	Progress() *StepProgress
+	SetProgressHandler(func(StepProgress))
+}
+
+// Minimum interval between two calls of the progress handler
+const progressInterval = time.Second
+
+// progressTracker can be embedded into steps to follow the status
+// messages of restic backup while it is running.
+type progressTracker struct {
+	mu       sync.Mutex
+	progress *StepProgress
+	handler  func(StepProgress)
+	notified time.Time
+}
+
+func (t *progressTracker) SetProgressHandler(handler func(StepProgress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.handler = handler
+}
+
+// Progress returns the last status, nil if restic did not report yet
+func (t *progressTracker) Progress() *StepProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.progress == nil {
+		return nil
+	}
+	p := *t.progress
+
+	return &p
+}
+
+func (t *progressTracker) resetProgress() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress = nil
+}
+
+func (t *progressTracker) setProgress(name string, status ResticStatus) {
+	t.mu.Lock()
+	p := StepProgress{Name: name, Updated: time.Now(), ResticStatus: status}
+	t.progress = &p
+	handler := t.handler
+	if handler == nil || (p.Updated.Sub(t.notified) < progressInterval && status.PercentDone < 1) {
+		t.mu.Unlock()
+		return
+	}
+	t.notified = p.Updated
+	t.mu.Unlock()
+
+	handler(p)
+}
+
+// progressWriter returns a writer for the stdout of restic backup. Status
+// messages are consumed, all other lines are passed to out for logging
+// and the summary.
+func (t *progressTracker) progressWriter(name string, out io.Writer) io.Writer {
+	return &statusWriter{tracker: t, name: name, out: out}
+}
+
+type statusWriter struct {
+	tracker *progressTracker
+	name    string
+	out     io.Writer
+	line    []byte
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.line[:i+1]); err != nil {
+			return 0, err
+		}
+		w.line = w.line[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *statusWriter) writeLine(line []byte) error {
+	if bytes.Contains(line, []byte(`"status"`)) {
+		var msg struct {
+			MessageType string `json:"message_type"`
+			ResticStatus
+		}
+		if err := json.Unmarshal(line, &msg); err == nil && msg.MessageType == "status" {
+			w.tracker.setProgress(w.name, msg.ResticStatus)
+			return nil
+		}
+	}
+
+	_, err := w.out.Write(line)
+
+	return err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RunReport is the outcome of a backup set run, it is passed to notifiers
+type RunReport struct {
+	Id       string       `json:"id"` // tagged as "run:<id>" to the snapshots
+	Set      string       `json:"set"`
+	Host     string       `json:"host"`
+	Trigger  string       `json:"trigger"` // schedule, startup, http or api
+	Status   string       `json:"status"`  // success, failed or skipped
+	Error    string       `json:"error,omitempty"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Steps    []StepReport `json:"steps"`
+
+	Anomalies     []Anomaly `json:"anomalies,omitempty"`
+	ForgetBlocked bool      `json:"forget_blocked,omitempty"` // see ANOMALY_BLOCK_FORGET
+}
+
+// snapshotFilters selects the snapshots of a path taken by the host and backup set of the run
+func (r *RunReport) snapshotFilters(path string) []string {
+	filters := []string{"--path", path}
+	if r.Host != "" {
+		filters = append(filters, "--host", r.Host)
+	}
+	d := BackupDestination{tag: setTag(r.Set)}
+
+	return append(filters, d.setTagArgs()...)
+}
+
+// StepReport is the outcome of a single step
+type StepReport struct {
+	Index       int             `json:"index"`
+	Type        string          `json:"type"`
+	Description string          `json:"description"`
+	Status      string          `json:"status"` // success, failed or skipped
+	Error       string          `json:"error,omitempty"`
+	Duration    float64         `json:"duration"` // seconds
+	Snapshots   []ResticSummary `json:"snapshots,omitempty"`
+}
+
+// Notifier is informed about the result of each backup set run
+type Notifier interface {
+	Name() string
+	Notify(r *RunReport) error
+}
+
+// StartNotifier is implemented by notifiers which want to know when a run begins
+type StartNotifier interface {
+	NotifyStart() error
+}
+
+// FailedSteps returns the number of failed or skipped steps
+func (r *RunReport) FailedSteps() int {
+	failed := 0
+	for _, s := range r.Steps {
+		if s.Status != "success" {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// BytesAdded returns the sum of bytes added to the repository by all snapshots
+func (r *RunReport) BytesAdded() int64 {
+	var added int64
+	for _, s := range r.Steps {
+		for _, snapshot := range s.Snapshots {
+			added += snapshot.DataAdded
+		}
+	}
+
+	return added
+}
+
+// Quiet is true for successful runs without anomalies, notifiers may skip them
+func (r *RunReport) Quiet() bool {
+	return r.Status == "success" && len(r.Anomalies) == 0
+}
+
+// Title is a short, human readable summary
+func (r *RunReport) Title() string {
+	switch {
+	case r.Status == "success" && len(r.Anomalies) > 0:
+		return fmt.Sprintf("Backup %s on %s succeeded with anomalies", r.Set, r.Host)
+	case r.Status == "success":
+		return fmt.Sprintf("Backup %s on %s succeeded", r.Set, r.Host)
+	case r.Status == "skipped":
+		return fmt.Sprintf("Backup %s on %s skipped", r.Set, r.Host)
+	}
+
+	return fmt.Sprintf("Backup %s on %s failed", r.Set, r.Host)
+}
+
+// Text is a human readable report with one line per step, without title
+func (r *RunReport) Text() string {
+	var b strings.Builder
+	if r.Error != "" {
+		b.WriteString("Error: " + r.Error + "\n")
+	}
+	fmt.Fprintf(&b, "%d of %d steps failed, %s added, duration %s\n",
+		r.FailedSteps(), len(r.Steps), formatBytes(r.BytesAdded()), r.Finished.Sub(r.Started).Round(time.Second))
+	for _, s := range r.Steps {
+		fmt.Fprintf(&b, "- %s %s: %s", s.Type, s.Description, s.Status)
+		if s.Error != "" {
+			b.WriteString(" (" + s.Error + ")")
+		}
+		for _, snapshot := range s.Snapshots {
+			fmt.Fprintf(&b, ", snapshot %s", snapshot.SnapshotId)
+		}
+		b.WriteString("\n")
+	}
+	for _, a := range r.Anomalies {
+		fmt.Fprintf(&b, "Anomaly: %s %s %s: %s %.0f, usually %.0f\n", a.Type, a.Description, a.Name, a.Metric, a.Value, a.Baseline)
+	}
+	if r.ForgetBlocked {
+		b.WriteString("Forget blocked because of anomalies\n")
+	}
+
+	return b.String()
+}
+
+// notify passes the report to all notifiers, failures are logged only
+func notify(notifiers []Notifier, r *RunReport) {
+	for _, n := range notifiers {
+		if err := n.Notify(r); err != nil {
+			logger.Error("notification failed", zap.String("notifier", n.Name()), zap.Error(err))
+			continue
+		}
+		logger.Debug("notification sent", zap.String("notifier", n.Name()))
+	}
+}
+
+// notifyStart informs all notifiers implementing StartNotifier, failures are logged only
+func notifyStart(notifiers []Notifier) {
+	for _, n := range notifiers {
+		s, ok := n.(StartNotifier)
+		if !ok {
+			continue
+		}
+		if err := s.NotifyStart(); err != nil {
+			logger.Error("start notification failed", zap.String("notifier", n.Name()), zap.Error(err))
+		}
+	}
+}
+
+// formatBytes returns a human readable size like "1.5 GiB"
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// parseSize parses sizes like "500", "10K", "1.5GiB" or "2 TB", units are binary like in restic
+func parseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if len(s) > 0 {
+		if i := strings.IndexByte("KMGTP", s[len(s)-1]); i >= 0 {
+			for ; i >= 0; i-- {
+				multiplier *= 1024
+			}
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size: " + value)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// ResticSummary is the summary message of "restic backup --json"
+type ResticSummary struct {
+	Name                string  `json:"name,omitempty"` // path or stdin filename, set by the agent
+	FilesNew            int64   `json:"files_new"`
+	FilesChanged        int64   `json:"files_changed"`
+	FilesUnmodified     int64   `json:"files_unmodified"`
+	DirsNew             int64   `json:"dirs_new"`
+	DirsChanged         int64   `json:"dirs_changed"`
+	DirsUnmodified      int64   `json:"dirs_unmodified"`
+	DataBlobs           int64   `json:"data_blobs"`
+	TreeBlobs           int64   `json:"tree_blobs"`
+	DataAdded           int64   `json:"data_added"`
+	TotalFilesProcessed int64   `json:"total_files_processed"`
+	TotalBytesProcessed int64   `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+	SnapshotId          string  `json:"snapshot_id"`
+}
+
+// parseResticSummary searches the json output of restic backup for the summary message
+func parseResticSummary(out []byte) *ResticSummary {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.Contains(line, []byte(`"summary"`)) {
+			continue
+		}
+
+		var msg struct {
+			MessageType string `json:"message_type"`
+			ResticSummary
+		}
+		if err := json.Unmarshal(line, &msg); err != nil || msg.MessageType != "summary" {
+			continue
+		}
+
+		return &msg.ResticSummary
+	}
+
+	return nil
+}
+
+// summaryCollector can be embedded into steps to collect the restic
+// summaries of a run, one step may create several snapshots.
+type summaryCollector struct {
+	mu        sync.Mutex
+	summaries []ResticSummary
+}
+
+// SummaryStep is implemented by steps which report their snapshots
+type SummaryStep interface {
+	Summaries() []ResticSummary
+}
+
+// SnapshotStep is implemented by steps with fixed snapshot paths, the
+// paths of other steps are taken from the summaries of the last run.
+type SnapshotStep interface {
+	SnapshotPaths() []string
+}
+
+func (c *summaryCollector) resetSummaries() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summaries = nil
+}
+
+// addSummary parses the output of restic backup, output without summary is ignored
+func (c *summaryCollector) addSummary(name string, out []byte) {
+	summary := parseResticSummary(out)
+	if summary == nil {
+		return
+	}
+	summary.Name = name
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summaries = append(c.summaries, *summary)
+}
+
+// Summaries returns the summaries of the last run
+func (c *summaryCollector) Summaries() []ResticSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]ResticSummary{}, c.summaries...)
+}
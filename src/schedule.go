@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// schedulable can be embedded into steps to order them within a run
+type schedulable struct {
+	priority int
+	after    []string
+}
+
+// OrderedStep is implemented by steps with a priority or dependencies
+type OrderedStep interface {
+	Priority() int
+	After() []string
+}
+
+// SetOrder sets the priority, higher starts first, and the steps to wait
+// for, referenced by type like "postgres" or type and description like
+// "volume:/data/app".
+func (s *schedulable) SetOrder(priority int, after []string) {
+	s.priority = priority
+	s.after = after
+}
+
+func (s *schedulable) Priority() int {
+	return s.priority
+}
+
+func (s *schedulable) After() []string {
+	return s.after
+}
+
+// stepMatches is true if ref is the type or "type:description" of s
+func stepMatches(s BackupStep, ref string) bool {
+	return ref == s.Type() || ref == s.Type()+":"+s.Description()
+}
+
+// SetMaxParallel limits the number of steps running at once, 0 is unlimited
+func (b *BackupSet) SetMaxParallel(n int) {
+	b.maxParallel = n
+}
+
+// ValidateOrder fails on dependency cycles between the configured steps,
+// discovered steps are checked at each run.
+func (b *BackupSet) ValidateOrder() error {
+	cycles := dependencyCycles(stepDependencies(b.steps))
+	if len(cycles) == 0 {
+		return nil
+	}
+	names := make([]string, len(cycles))
+	for n, i := range cycles {
+		names[n] = b.steps[i].Type() + ":" + b.steps[i].Description()
+	}
+
+	return errors.New("dependency cycle between steps " + strings.Join(names, ", "))
+}
+
+// stepDependencies resolves the dependencies of each step to indexes,
+// references matching no step are ignored
+func stepDependencies(steps []BackupStep) [][]int {
+	deps := make([][]int, len(steps))
+	for i, s := range steps {
+		o, ok := s.(OrderedStep)
+		if !ok {
+			continue
+		}
+		for _, ref := range o.After() {
+			matched := false
+			for j, t := range steps {
+				if j != i && stepMatches(t, ref) {
+					deps[i] = append(deps[i], j)
+					matched = true
+				}
+			}
+			if !matched {
+				logger.Warn("step dependency matches no step", zap.Int("index", i), zap.String("after", ref))
+			}
+		}
+	}
+
+	return deps
+}
+
+// dependencyCycles returns the indexes of steps which depend on themselves
+// through other steps, in ascending order
+func dependencyCycles(deps [][]int) []int {
+	var cycles []int
+	for i := range deps {
+		seen := make([]bool, len(deps))
+		queue := append([]int{}, deps[i]...)
+		for len(queue) > 0 {
+			j := queue[0]
+			queue = queue[1:]
+			if j == i {
+				cycles = append(cycles, i)
+				break
+			}
+			if !seen[j] {
+				seen[j] = true
+				queue = append(queue, deps[j]...)
+			}
+		}
+	}
+
+	return cycles
+}
+
+// scheduleSteps calls fn for each step in a goroutine, at most maxParallel
+// at once. A step is ready when all steps it runs after have finished,
+// failed or not; ready steps start by priority, then in configured order.
+// Steps which never became ready are returned with the reason, they are
+// part of a dependency cycle or wait for one.
+func (b *BackupSet) scheduleSteps(steps []BackupStep, fn func(i int)) map[int]string {
+	deps := stepDependencies(steps)
+	order := make([]int, len(steps))
+	priority := make([]int, len(steps))
+	for i, s := range steps {
+		order[i] = i
+		if o, ok := s.(OrderedStep); ok {
+			priority[i] = o.Priority()
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return priority[order[a]] > priority[order[b]] })
+
+	done := make([]bool, len(steps))
+	started := make([]bool, len(steps))
+	ready := func(i int) bool {
+		for _, j := range deps[i] {
+			if !done[j] {
+				return false
+			}
+		}
+		return true
+	}
+
+	finished := make(chan int)
+	running := 0
+	for {
+		for _, i := range order {
+			if b.maxParallel > 0 && running >= b.maxParallel {
+				break
+			}
+			if started[i] || !ready(i) {
+				continue
+			}
+			started[i] = true
+			running++
+			go func(i int) {
+				fn(i)
+				finished <- i
+			}(i)
+		}
+		if running == 0 {
+			break
+		}
+		done[<-finished] = true
+		running--
+	}
+
+	blocked := map[int]string{}
+	for i := range steps {
+		if !started[i] {
+			blocked[i] = "waits for a dependency cycle"
+		}
+	}
+	for _, i := range dependencyCycles(deps) {
+		blocked[i] = "dependency cycle"
+	}
+
+	return blocked
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderedVolume returns a volume step with the given order
+func orderedVolume(path string, priority int, after ...string) *volumeStep {
+	s := NewVolumeStep(path)
+	s.SetOrder(priority, after)
+
+	return s
+}
+
+// runSchedule runs steps which take a moment each, it returns the start
+// order, the highest number of steps running at once and the blocked steps
+func runSchedule(maxParallel int, steps []BackupStep) ([]int, int, map[int]string) {
+	b := &BackupSet{}
+	b.SetMaxParallel(maxParallel)
+
+	var (
+		mu      sync.Mutex
+		started []int
+		running int
+		peak    int
+	)
+	blocked := b.scheduleSteps(steps, func(i int) {
+		mu.Lock()
+		started = append(started, i)
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	return started, peak, blocked
+}
+
+func TestScheduleSteps(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxParallel int
+		steps       []BackupStep
+		started     []int // nil to skip the check, parallel steps start in any order
+		peak        int
+		blocked     map[int]string
+	}{
+		{"unlimited", 0, []BackupStep{
+			orderedVolume("/a", 0), orderedVolume("/b", 0), orderedVolume("/c", 0),
+		}, nil, 3, map[int]string{}},
+		{"sequential in configured order", 1, []BackupStep{
+			orderedVolume("/a", 0), orderedVolume("/b", 0), orderedVolume("/c", 0),
+		}, []int{0, 1, 2}, 1, map[int]string{}},
+		{"sequential by priority", 1, []BackupStep{
+			orderedVolume("/a", 0), orderedVolume("/b", -1), orderedVolume("/c", 5), orderedVolume("/d", 5),
+		}, []int{2, 3, 0, 1}, 1, map[int]string{}},
+		{"max parallel", 2, []BackupStep{
+			orderedVolume("/a", 0), orderedVolume("/b", 0), orderedVolume("/c", 0), orderedVolume("/d", 0),
+		}, nil, 2, map[int]string{}},
+		{"dependency before priority", 1, []BackupStep{
+			orderedVolume("/a", 9, "sqlite"), NewSqliteStep("/db"), orderedVolume("/c", 0),
+		}, []int{1, 0, 2}, 1, map[int]string{}},
+		{"dependency by description", 0, []BackupStep{
+			orderedVolume("/wal", 0, "volume:/dump"), orderedVolume("/dump", 0), orderedVolume("/other", 0, "volume:/wal"),
+		}, []int{1, 0, 2}, 1, map[int]string{}},
+		{"unknown dependency is ignored", 1, []BackupStep{
+			orderedVolume("/a", 0, "postgres"), orderedVolume("/b", 0),
+		}, []int{0, 1}, 1, map[int]string{}},
+		{"cycle", 0, []BackupStep{
+			orderedVolume("/a", 0, "volume:/b"), orderedVolume("/b", 0, "volume:/a"),
+			orderedVolume("/c", 0, "volume:/a"), orderedVolume("/d", 0),
+		}, []int{3}, 1, map[int]string{
+			0: "dependency cycle", 1: "dependency cycle", 2: "waits for a dependency cycle",
+		}},
+	}
+	for _, tt := range tests {
+		started, peak, blocked := runSchedule(tt.maxParallel, tt.steps)
+		if tt.started != nil && !reflect.DeepEqual(started, tt.started) {
+			t.Errorf("%s: started %v, expected %v", tt.name, started, tt.started)
+		}
+		if peak != tt.peak {
+			t.Errorf("%s: %d steps running at once, expected %d", tt.name, peak, tt.peak)
+		}
+		if !reflect.DeepEqual(blocked, tt.blocked) {
+			t.Errorf("%s: blocked %v, expected %v", tt.name, blocked, tt.blocked)
+		}
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	b := &BackupSet{}
+	b.AddStep(orderedVolume("/a", 0, "sqlite"))
+	b.AddStep(NewSqliteStep("/db"))
+	if err := b.ValidateOrder(); err != nil {
+		t.Errorf("valid order: %v", err)
+	}
+
+	sqlite := NewSqliteStep("/db2")
+	sqlite.SetOrder(0, []string{"volume:/a"})
+	b.AddStep(sqlite)
+	err := b.ValidateOrder()
+	if err == nil || err.Error() != "dependency cycle between steps volume:/a, sqlite:/db2" {
+		t.Errorf("cycle: %v", err)
+	}
+}
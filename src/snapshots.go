@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ResticNode is a file or directory of "restic ls --json"
+type ResticNode struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"` // file, dir, symlink, ...
+	Path  string `json:"path"`
+	Size  int64  `json:"size,omitempty"`
+	Mode  uint32 `json:"mode,omitempty"`
+	Mtime string `json:"mtime,omitempty"`
+	Uid   int    `json:"uid"`
+	Gid   int    `json:"gid"`
+}
+
+// Archive formats of restic dump for directories
+var dumpArchives = []string{"tar", "zip"}
+
+// Snapshots are selected by id, "latest" would pick the newest snapshot of any host and set
+var snapshotIdPattern = regexp.MustCompile(`^[0-9a-f]{8,64}$`)
+
+// validateSnapshotPath checks user input before it is passed to restic as arguments
+func validateSnapshotPath(snapshot string, p string) error {
+	if !snapshotIdPattern.MatchString(snapshot) {
+		return errors.New("invalid snapshot id")
+	}
+	if !path.IsAbs(p) {
+		return errors.New("path must be absolute")
+	}
+
+	return nil
+}
+
+// listNodes returns the direct children of a directory in a snapshot
+func listNodes(snapshot string, dir string) ([]ResticNode, error) {
+	if err := validateSnapshotPath(snapshot, dir); err != nil {
+		return nil, err
+	}
+	dir = path.Clean(dir)
+	out, err := resticOutput("ls", "--json", snapshot, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first line is the snapshot, followed by one node per line
+	nodes := []ResticNode{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var node struct {
+			StructType string `json:"struct_type"`
+			ResticNode
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &node); err != nil || node.StructType != "node" {
+			continue
+		}
+		// restic lists the directory itself as well
+		if node.Path == dir {
+			continue
+		}
+		nodes = append(nodes, node.ResticNode)
+	}
+
+	return nodes, nil
+}
+
+// statNode returns the node of a path in a snapshot, nil if it does not exist
+func statNode(snapshot string, p string) (*ResticNode, error) {
+	p = path.Clean(p)
+	if p == "/" {
+		return &ResticNode{Name: "/", Type: "dir", Path: "/"}, nil
+	}
+	nodes, err := listNodes(snapshot, path.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		if n.Path == p {
+			return &n, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// dumpNode writes a file or a directory as archive to w, restic is killed when ctx is done
+func dumpNode(ctx context.Context, w io.Writer, snapshot string, node *ResticNode, archive string) error {
+	args := []string{"dump"}
+	if node.Type == "dir" {
+		if !isDumpArchive(archive) {
+			return errors.New("unknown archive format: " + archive)
+		}
+		args = append(args, "--archive", archive)
+	}
+	args = append(args, snapshot, node.Path)
+	args = append(args, resticLimits.args(time.Now())...)
+
+	cmd := exec.CommandContext(ctx, "restic", args...)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			logger.Info("restic dump aborted", zap.String("snapshot", snapshot), zap.String("path", node.Path), zap.Error(ctx.Err()))
+			return ctx.Err()
+		}
+		logger.Error("command restic dump failed", zap.String("snapshot", snapshot), zap.String("path", node.Path),
+			zap.String("stderr", stderr.String()), zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func isDumpArchive(archive string) bool {
+	return containsString(dumpArchives, archive)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
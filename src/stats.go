@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// resticStats is the output of "restic stats --json"
+type resticStats struct {
+	TotalSize             int64   `json:"total_size"`
+	TotalUncompressedSize int64   `json:"total_uncompressed_size"`
+	CompressionRatio      float64 `json:"compression_ratio"`
+	TotalFileCount        int64   `json:"total_file_count"`
+	TotalBlobCount        int64   `json:"total_blob_count"`
+	SnapshotsCount        int64   `json:"snapshots_count"`
+}
+
+// ResticSnapshot is an element of "restic snapshots --json"
+type ResticSnapshot struct {
+	Id       string    `json:"id"`
+	ShortId  string    `json:"short_id"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Paths    []string  `json:"paths"`
+	Tags     []string  `json:"tags,omitempty"`
+
+	// Summary of the backup, stored by restic 0.17 and later
+	Summary *ResticSummary `json:"summary,omitempty"`
+}
+
+// repositoryStats collects repository wide statistics, which are too
+// expensive to be gathered on each scrape.
+type repositoryStats struct {
+	running safeBool
+	metrics *MetricsCollection
+
+	mu   sync.Mutex
+	last *RepositoryStatsResult
+}
+
+// RepositoryStatsResult is the last collection, returned by the stats api
+type RepositoryStatsResult struct {
+	Collected        time.Time `json:"collected"`
+	Size             int64     `json:"size"`
+	UncompressedSize int64     `json:"uncompressed_size"`
+	RestoreSize      int64     `json:"restore_size"`
+	Blobs            int64     `json:"blobs"`
+	DataBlobs        int64     `json:"data_blobs"`
+	TreeBlobs        int64     `json:"tree_blobs"`
+	Snapshots        int       `json:"snapshots"`
+}
+
+func NewRepositoryStats(m *MetricsCollection) *repositoryStats {
+	return &repositoryStats{metrics: m}
+}
+
+// Run implements cron.Job
+func (r *repositoryStats) Run() {
+	if !r.running.SetIf(true, false) {
+		logger.Warn("repository statistics already running")
+		return
+	}
+	defer r.running.Set(false)
+
+	if err := r.Collect(); err != nil {
+		logger.Error("failed to collect repository statistics", zap.Error(err))
+	}
+}
+
+func (r *repositoryStats) Name() string {
+	return "repository-stats"
+}
+
+// Notify collects the statistics after a backup run
+func (r *repositoryStats) Notify(report *RunReport) error {
+	r.Run()
+
+	return nil
+}
+
+// Last returns the result of the last successful collection, nil if there is none
+func (r *repositoryStats) Last() *RepositoryStatsResult {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last
+}
+
+func (r *repositoryStats) Collect() error {
+	logger.Debug("collecting repository statistics")
+	m := r.metrics
+
+	raw, err := r.stats("raw-data")
+	if err != nil {
+		return err
+	}
+	m.RepositorySize.Set(float64(raw.TotalSize))
+	m.RepositoryUncompressedSize.Set(float64(raw.TotalUncompressedSize))
+	m.RepositoryBlobs.Set(float64(raw.TotalBlobCount))
+
+	restore, err := r.stats("restore-size")
+	if err != nil {
+		return err
+	}
+	m.RepositoryRestoreSize.Set(float64(restore.TotalSize))
+
+	data, tree, err := r.countBlobs()
+	if err != nil {
+		return err
+	}
+	m.DataBlobs.Set(float64(data))
+	m.TreeBlobs.Set(float64(tree))
+
+	snapshots, err := listSnapshots()
+	if err != nil {
+		return err
+	}
+	r.setSnapshotMetrics(snapshots)
+
+	r.mu.Lock()
+	r.last = &RepositoryStatsResult{
+		Collected:        time.Now(),
+		Size:             raw.TotalSize,
+		UncompressedSize: raw.TotalUncompressedSize,
+		RestoreSize:      restore.TotalSize,
+		Blobs:            raw.TotalBlobCount,
+		DataBlobs:        data,
+		TreeBlobs:        tree,
+		Snapshots:        len(snapshots),
+	}
+	r.mu.Unlock()
+
+	logger.Info("repository statistics collected", zap.Int64("size", raw.TotalSize), zap.Int("snapshots", len(snapshots)))
+
+	return nil
+}
+
+func (r *repositoryStats) stats(mode string) (*resticStats, error) {
+	out, err := resticOutput("stats", "--json", "--mode", mode)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &resticStats{}
+	if err = json.Unmarshal(out, stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// countBlobs counts data and tree blobs, "restic list blobs" prints "<type> <id>" per line
+func (r *repositoryStats) countBlobs() (data int64, tree int64, err error) {
+	cmd := resticCommand("list", "blobs", "--quiet")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = cmd.Start(); err != nil {
+		return 0, 0, err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		switch {
+		case strings.HasPrefix(scanner.Text(), "data "):
+			data++
+		case strings.HasPrefix(scanner.Text(), "tree "):
+			tree++
+		}
+	}
+	if err = cmd.Wait(); err != nil {
+		logger.Error("command restic list blobs failed", zap.Error(err))
+		return 0, 0, err
+	}
+
+	return data, tree, nil
+}
+
+// setSnapshotMetrics sets count and time of snapshots per host and path. The
+// time is exported instead of the age, which would stand still between runs.
+func (r *repositoryStats) setSnapshotMetrics(snapshots []ResticSnapshot) {
+	type group struct {
+		count          int
+		oldest, newest time.Time
+	}
+	groups := map[[2]string]*group{}
+	for _, s := range snapshots {
+		key := [2]string{s.Hostname, strings.Join(s.Paths, ",")}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{oldest: s.Time, newest: s.Time}
+			groups[key] = g
+		}
+		g.count++
+		if s.Time.Before(g.oldest) {
+			g.oldest = s.Time
+		}
+		if s.Time.After(g.newest) {
+			g.newest = s.Time
+		}
+	}
+
+	// Forgotten snapshot groups must disappear
+	m := r.metrics
+	m.SnapshotCount.Reset()
+	m.SnapshotOldestTime.Reset()
+	m.SnapshotNewestTime.Reset()
+	for key, g := range groups {
+		labels := prometheus.Labels{"host": key[0], "path": key[1]}
+		m.SnapshotCount.With(labels).Set(float64(g.count))
+		m.SnapshotOldestTime.With(labels).Set(float64(g.oldest.Unix()))
+		m.SnapshotNewestTime.With(labels).Set(float64(g.newest.Unix()))
+	}
+}
+
+// listSnapshots returns all snapshots of the repository, args are passed
+// as filter, e.g. "--host", "foo"
+func listSnapshots(args ...string) ([]ResticSnapshot, error) {
+	out, err := resticOutput(append([]string{"snapshots", "--json"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []ResticSnapshot
+	if err = json.Unmarshal(out, &snapshots); err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// resticOutput runs restic and returns stdout, failures are logged
+func resticOutput(args ...string) ([]byte, error) {
+	out, err := resticCommand(args...).Output()
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok {
+			logger.Error("command restic "+args[0]+" failed", zap.Error(err),
+				zap.ByteString("stderr", exiterr.Stderr), zap.Int("code", exiterr.ExitCode()),
+			)
+		} else {
+			logger.Error("command restic "+args[0]+" failed", zap.Error(err))
+		}
+		return nil, err
+	}
+
+	return out, nil
+}
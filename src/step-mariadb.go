@@ -3,31 +3,64 @@ package main
 import (
 	"bytes"
 	"errors"
+	"io/ioutil"
+	"net"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 )
 
+// System schemas which are skipped when dumping all databases
+var mariadbSystemDatabases = []string{"information_schema", "performance_schema", "sys"}
+
 type mariadbStep struct {
 	running     safeBool
 	destination BackupDestination
 	host        string
 	port        int
+	socket      string
 	user        string
 	password    string
 	database    string
+	all         bool
+	tls         mariadbTls
 	name        string
+
+	hookable
+	summaryCollector
+	progressTracker
+	taggable
+	schedulable
 }
 
+// mariadbTls is written to the option file if enabled, empty values are omitted
+type mariadbTls struct {
+	enabled bool   // ssl
+	ca      string // ssl-ca
+	cert    string // ssl-cert
+	key     string // ssl-key
+	verify  bool   // ssl-verify-server-cert
+}
+
+// NewMariadbStep creates a mariadb step, host may be "host" or "host:port"
 func NewMariadbStep(host string, user string, password string, database string) (s *mariadbStep, err error) {
 	s = &mariadbStep{}
-	// TODO: match host for 's/(:\d+)$//' and $1 => port
 	s.host = host
 	s.port = 3306
 	s.user = user
 	s.password = password
 	s.database = database
 
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		s.host = h
+		if s.port, err = strconv.Atoi(p); err != nil {
+			return nil, errors.New("invalid mariadb port: " + p)
+		}
+	}
+
 	// no sub-directory, see https://github.com/restic/restic/pull/2206 (fixed in master)
 	s.name = "/mysql-" + s.host + "-" + s.database + ".dmp"
 
@@ -43,6 +76,10 @@ func (s *mariadbStep) Type() string {
 }
 
 func (s *mariadbStep) Description() string {
+	if s.all {
+		return s.user + "@" + s.host + "/*"
+	}
+
 	return s.user + "@" + s.host + "/" + s.database
 }
 
@@ -54,23 +91,107 @@ func (s *mariadbStep) SetName(name string) {
 	s.name = name
 }
 
+// SetSocket connects by unix socket instead of host and port
+func (s *mariadbStep) SetSocket(socket string) {
+	s.socket = socket
+	if s.host == "" {
+		s.host = "localhost"
+		s.name = "/mysql-" + s.host + "-" + s.database + ".dmp"
+	}
+}
+
+// SetTls enables TLS, certificate files are optional
+func (s *mariadbStep) SetTls(ca string, cert string, key string, verify bool) {
+	s.tls = mariadbTls{enabled: true, ca: ca, cert: cert, key: key, verify: verify}
+}
+
+// SetAllDatabases switches to dumping every user database of the server
+// into a separate file.
+func (s *mariadbStep) SetAllDatabases(all bool) {
+	s.all = all
+}
+
 func (s *mariadbStep) Run(m *MetricsCollection) (err error) {
 	if !s.running.SetIf(true, false) {
 		return errors.New("Backup step already running")
 	}
 	defer s.running.Set(false)
+	s.resetSummaries()
+	s.resetProgress()
+
+	// Credentials are passed in an option file, they are visible in ps otherwise
+	defaults, err := s.writeDefaults()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(defaults)
+
+	if !s.all {
+		return s.dumpDatabase(defaults, s.database, s.name)
+	}
+
+	databases, err := s.listDatabases(defaults)
+	if err != nil {
+		return err
+	}
+	logger.Debug("found databases", zap.Strings("databases", databases))
+
+	// Continue with the other databases if one fails, report the last error
+	for _, database := range databases {
+		if errDb := s.dumpDatabase(defaults, database, "/mysql-"+s.host+"-"+database+".dmp"); errDb != nil {
+			err = errDb
+		}
+	}
+
+	return err
+}
+
+// listDatabases returns all databases except the system schemas
+func (s *mariadbStep) listDatabases(defaults string) ([]string, error) {
+	args := []string{"--defaults-extra-file=" + defaults, "-N", "-B", "-e", "SHOW DATABASES"}
+	out, err := exec.Command("mariadb", args...).Output()
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok {
+			logger.Error("command mariadb failed", zap.Error(err),
+				zap.ByteString("stderr", exiterr.Stderr), zap.Int("code", exiterr.ExitCode()),
+			)
+		} else {
+			logger.Error("command mariadb failed", zap.Error(err))
+		}
+		return nil, err
+	}
+
+	var databases []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || isMariadbSystemDatabase(line) {
+			continue
+		}
+		databases = append(databases, line)
+	}
 
-	args := []string{"-h", s.host, "-u", s.user, "--password=" + s.password}
-	args = append(args, s.database)
+	return databases, nil
+}
+
+func (s *mariadbStep) dumpDatabase(defaults string, database string, name string) (err error) {
+	// --defaults-extra-file must be the first argument
+	args := []string{"--defaults-extra-file=" + defaults}
+	args = append(args, "--single-transaction", "--routines", "--triggers", "--events")
+	args = append(args, database)
 	cmdDb := exec.Command("mariadb-dump", args...)
+	stderrDb := bytes.NewBuffer(nil)
+	cmdDb.Stderr = stderrDb
 
 	args = []string{"backup", "--json", "--host", s.destination.hostname}
-	args = append(args, "--stdin", "--stdin-filename", s.name)
+	args = append(args, s.tagArgs()...)
+	args = append(args, s.destination.limitArgs()...)
+	args = append(args, "--stdin", "--stdin-filename", name)
 	cmd := exec.Command("restic", args...)
 
 	stdout := bytes.NewBuffer(nil)
 	stderr := bytes.NewBuffer(nil)
-	cmd.Stdout = stdout
+	cmd.Stdout = s.progressWriter(name, stdout)
 	cmd.Stderr = stderr
 
 	// Better this way or vice versa - any difference?
@@ -96,11 +217,11 @@ func (s *mariadbStep) Run(m *MetricsCollection) (err error) {
 	if errDb != nil {
 		exiterr, ok := errDb.(*exec.ExitError)
 		if ok {
-			logger.Info("command mariadb-dump failed", zap.Error(err),
-				zap.ByteString("stderr", exiterr.Stderr), zap.Int("code", exiterr.ExitCode()),
+			logger.Info("command mariadb-dump failed", zap.String("name", name), zap.Error(errDb),
+				zap.String("stderr", stderrDb.String()), zap.Int("code", exiterr.ExitCode()),
 			)
 		} else {
-			logger.Error("command mariadb-dump failed", zap.Error(err))
+			logger.Error("command mariadb-dump failed", zap.String("name", name), zap.Error(errDb))
 		}
 		return errDb
 	}
@@ -108,12 +229,12 @@ func (s *mariadbStep) Run(m *MetricsCollection) (err error) {
 	if err != nil {
 		exiterr, ok := err.(*exec.ExitError)
 		if ok {
-			logger.Info("command restic backup failed",
+			logger.Info("command restic backup failed", zap.String("name", name),
 				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
 				zap.Int("code", exiterr.ExitCode()),
 			)
 		} else {
-			logger.Error("command restic backup failed",
+			logger.Error("command restic backup failed", zap.String("name", name),
 				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
 			)
 		}
@@ -121,8 +242,69 @@ func (s *mariadbStep) Run(m *MetricsCollection) (err error) {
 	}
 
 	// ok
-	logger.Info("ok", zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
-	// TODO: parse output, fill metrics, see volumeStep
+	logger.Info("ok", zap.String("name", name), zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
+	s.addSummary(name, stdout.Bytes())
 
 	return nil
 }
+
+// writeDefaults creates a private option file with the [client] settings
+func (s *mariadbStep) writeDefaults() (string, error) {
+	f, err := ioutil.TempFile("", "restic-agent-mariadb-*.cnf")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	text := "[client]\n"
+	text += "user=" + quoteMariadbOption(s.user) + "\n"
+	text += "password=" + quoteMariadbOption(s.password) + "\n"
+	if s.socket != "" {
+		text += "socket=" + quoteMariadbOption(s.socket) + "\n"
+	} else {
+		text += "host=" + quoteMariadbOption(s.host) + "\n"
+		text += "port=" + strconv.Itoa(s.port) + "\n"
+	}
+	if s.tls.enabled {
+		text += "ssl\n"
+		if s.tls.ca != "" {
+			text += "ssl-ca=" + quoteMariadbOption(s.tls.ca) + "\n"
+		}
+		if s.tls.cert != "" {
+			text += "ssl-cert=" + quoteMariadbOption(s.tls.cert) + "\n"
+		}
+		if s.tls.key != "" {
+			text += "ssl-key=" + quoteMariadbOption(s.tls.key) + "\n"
+		}
+		if s.tls.verify {
+			text += "ssl-verify-server-cert\n"
+		}
+	}
+
+	// TempFile creates the file with mode 0600
+	if _, err = f.WriteString(text); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// quoteMariadbOption quotes a value for an option file, see
+// https://mariadb.com/kb/en/configuring-mariadb-with-option-files/
+func quoteMariadbOption(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+
+	return "\"" + value + "\""
+}
+
+func isMariadbSystemDatabase(database string) bool {
+	for _, d := range mariadbSystemDatabases {
+		if database == d {
+			return true
+		}
+	}
+
+	return false
+}
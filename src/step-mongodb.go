@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+type mongodbStep struct {
+	running     safeBool
+	destination BackupDestination
+	uri         string
+	authDb      string
+	database    string
+	include     []string
+	exclude     []string
+	oplog       bool
+	name        string
+
+	hookable
+	summaryCollector
+	progressTracker
+	taggable
+	schedulable
+}
+
+func NewMongodbStep(uri string, authDb string, database string) (s *mongodbStep, err error) {
+	if uri == "" {
+		return nil, errors.New("MongoDB connection uri is empty")
+	}
+
+	s = &mongodbStep{}
+	s.uri = uri
+	s.authDb = authDb
+	s.database = database
+
+	// no sub-directory, see https://github.com/restic/restic/pull/2206 (fixed in master)
+	s.name = "/mongodb-" + s.hostname() + ".archive.gz"
+	if s.database != "" {
+		s.name = "/mongodb-" + s.hostname() + "-" + s.database + ".archive.gz"
+	}
+
+	return s, nil
+}
+
+func (s *mongodbStep) IsRunning() bool {
+	return s.running.Get()
+}
+
+func (s *mongodbStep) Type() string {
+	return "mongodb"
+}
+
+func (s *mongodbStep) Description() string {
+	if s.database == "" {
+		return s.hostname()
+	}
+
+	return s.hostname() + "/" + s.database
+}
+
+func (s *mongodbStep) SetDestination(destination BackupDestination) {
+	s.destination = destination
+}
+
+func (s *mongodbStep) SetName(name string) {
+	s.name = name
+}
+
+func (s *mongodbStep) SnapshotPaths() []string {
+	return []string{s.name}
+}
+
+// SetCollections restricts the dump to matching namespaces, passed as
+// --nsInclude and --nsExclude ("db.collection", wildcards allowed).
+func (s *mongodbStep) SetCollections(include []string, exclude []string) {
+	s.include = include
+	s.exclude = exclude
+}
+
+// SetOplog enables a point-in-time dump on replica sets. mongodump only
+// supports this for full dumps, so no database or collection filter may be set.
+func (s *mongodbStep) SetOplog(oplog bool) error {
+	if oplog && (s.database != "" || len(s.include) > 0 || len(s.exclude) > 0) {
+		return errors.New("MongoDB oplog requires a full dump without database or collection filters")
+	}
+	s.oplog = oplog
+
+	return nil
+}
+
+// hostname returns the host part of the uri without credentials for
+// logging and naming, the uri itself may contain a password.
+func (s *mongodbStep) hostname() string {
+	u, err := parseMongodbUri(s.uri)
+	if err != nil || u == "" {
+		return "mongodb"
+	}
+
+	return u
+}
+
+func (s *mongodbStep) Run(m *MetricsCollection) (err error) {
+	if !s.running.SetIf(true, false) {
+		return errors.New("Backup step already running")
+	}
+	defer s.running.Set(false)
+	s.resetSummaries()
+	s.resetProgress()
+
+	// The uri is written to a private config file, it is visible in ps otherwise
+	config, err := s.writeConfig()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config)
+
+	args := []string{"--config=" + config, "--archive", "--gzip"}
+	args = append(args, s.filterArgs()...)
+	if s.oplog {
+		args = append(args, "--oplog")
+	}
+	cmdDb := exec.Command("mongodump", args...)
+	stderrDb := bytes.NewBuffer(nil)
+	cmdDb.Stderr = stderrDb
+
+	args = []string{"backup", "--json", "--host", s.destination.hostname}
+	args = append(args, s.tagArgs()...)
+	args = append(args, s.destination.limitArgs()...)
+	args = append(args, "--stdin", "--stdin-filename", s.name)
+	cmd := exec.Command("restic", args...)
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdout = s.progressWriter(s.name, stdout)
+	cmd.Stderr = stderr
+
+	cmd.Stdin, err = cmdDb.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	// Start processes
+	if err = cmdDb.Start(); err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Wait for dump to complete
+	errDb := cmdDb.Wait()
+	// Wait for snapshot writing to complete
+	err = cmd.Wait()
+
+	if errDb != nil {
+		exiterr, ok := errDb.(*exec.ExitError)
+		if ok {
+			logger.Info("command mongodump failed", zap.Error(errDb),
+				zap.String("stderr", stderrDb.String()), zap.Int("code", exiterr.ExitCode()),
+			)
+		} else {
+			logger.Error("command mongodump failed", zap.Error(errDb))
+		}
+		return errDb
+	}
+
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok {
+			logger.Info("command restic backup failed",
+				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
+				zap.Int("code", exiterr.ExitCode()),
+			)
+		} else {
+			logger.Error("command restic backup failed",
+				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	// ok
+	logger.Info("ok", zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
+	s.addSummary(s.name, stdout.Bytes())
+
+	return nil
+}
+
+// Restore streams the archive of the given snapshot into mongorestore,
+// existing documents are kept, collections are not dropped.
+func (s *mongodbStep) Restore(snapshot string) (err error) {
+	if !s.running.SetIf(true, false) {
+		return errors.New("Backup step already running")
+	}
+	defer s.running.Set(false)
+
+	config, err := s.writeConfig()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config)
+
+	args := []string{"dump", "--host", s.destination.hostname, "--path", s.name}
+	args = append(args, s.destination.setTagArgs()...)
+	args = append(args, s.destination.limitArgs()...)
+	args = append(args, snapshot, s.name)
+	cmd := exec.Command("restic", args...)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	args = []string{"--config=" + config, "--archive", "--gzip"}
+	args = append(args, s.filterArgs()...)
+	if s.oplog {
+		args = append(args, "--oplogReplay")
+	}
+	cmdDb := exec.Command("mongorestore", args...)
+	stdoutDb := bytes.NewBuffer(nil)
+	stderrDb := bytes.NewBuffer(nil)
+	cmdDb.Stdout = stdoutDb
+	cmdDb.Stderr = stderrDb
+
+	cmdDb.Stdin, err = cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	// Start processes
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	if err = cmdDb.Start(); err != nil {
+		return err
+	}
+
+	// Wait for dump to complete
+	err = cmd.Wait()
+	// Wait for restore to complete
+	errDb := cmdDb.Wait()
+
+	if err != nil {
+		logger.Error("command restic dump failed", zap.String("stderr", stderr.String()), zap.Error(err))
+		return err
+	}
+	if errDb != nil {
+		logger.Error("command mongorestore failed",
+			zap.String("stdout", stdoutDb.String()), zap.String("stderr", stderrDb.String()), zap.Error(errDb),
+		)
+		return errDb
+	}
+
+	// ok
+	logger.Info("restore done", zap.String("snapshot", snapshot), zap.String("stderr", stderrDb.String()))
+
+	return nil
+}
+
+func (s *mongodbStep) filterArgs() []string {
+	var args []string
+	if s.authDb != "" {
+		args = append(args, "--authenticationDatabase="+s.authDb)
+	}
+	if s.database != "" {
+		args = append(args, "--db="+s.database)
+	}
+	for _, ns := range s.include {
+		args = append(args, "--nsInclude="+ns)
+	}
+	for _, ns := range s.exclude {
+		args = append(args, "--nsExclude="+ns)
+	}
+
+	return args
+}
+
+// writeConfig creates a temporary mongo tools config file containing the uri
+func (s *mongodbStep) writeConfig() (string, error) {
+	f, err := ioutil.TempFile("", "restic-agent-mongodb-*.yml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// TempFile creates the file with mode 0600
+	if _, err = f.WriteString("uri: " + strconv.Quote(s.uri) + "\n"); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// parseMongodbUri returns the host list of a mongodb:// or mongodb+srv:// uri
+func parseMongodbUri(uri string) (string, error) {
+	var rest string
+	switch {
+	case strings.HasPrefix(uri, "mongodb://"):
+		rest = strings.TrimPrefix(uri, "mongodb://")
+	case strings.HasPrefix(uri, "mongodb+srv://"):
+		rest = strings.TrimPrefix(uri, "mongodb+srv://")
+	default:
+		return "", errors.New("invalid MongoDB uri scheme")
+	}
+
+	// credentials
+	if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+		rest = rest[i+1:]
+	}
+	// path and options
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		rest = rest[:i]
+	}
+
+	return rest, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestEscapePgpass(t *testing.T) {
+	tests := []struct {
+		value   string
+		escaped string
+	}{
+		{"secret", "secret"},
+		{"a:b", `a\:b`},
+		{`a\b`, `a\\b`},
+		{`a\:b`, `a\\\:b`},
+		{"::", `\:\:`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if escaped := escapePgpass(tt.value); escaped != tt.escaped {
+			t.Errorf("%q: escaped %q, expected %q", tt.value, escaped, tt.escaped)
+		}
+	}
+}
+
+func TestPostgresDumpOptions(t *testing.T) {
+	tests := []struct {
+		options postgresDumpOptions
+		fail    bool
+	}{
+		{postgresDumpOptions{Compress: -1}, false},
+		{postgresDumpOptions{Format: "custom", Compress: 9}, false},
+		{postgresDumpOptions{Format: "directory", Compress: 5, Jobs: 4}, false},
+		{postgresDumpOptions{Format: "tar", Compress: -1}, false},
+		{postgresDumpOptions{Format: "tar", Compress: 0}, false},
+		{postgresDumpOptions{Format: "tar", Compress: 1}, true},
+		{postgresDumpOptions{Format: "custom", Compress: 10}, true},
+		{postgresDumpOptions{Format: "custom", Compress: -2}, true},
+		{postgresDumpOptions{Format: "custom", Compress: -1, Jobs: 2}, true},
+		{postgresDumpOptions{Format: "sql", Compress: -1}, true},
+	}
+	for _, tt := range tests {
+		s, err := NewPostgresStep("db", "app", "secret", "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetDumpOptions(tt.options); (err != nil) != tt.fail {
+			t.Errorf("%+v: error %v, expected failure %v", tt.options, err, tt.fail)
+		}
+	}
+}
+
+func TestNewPostgresStep(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string // host:port
+		name     string
+		fail     bool
+	}{
+		{"db", "db:5432", "/psql-db-app.dmp", false},
+		{"db:5433", "db:5433", "/psql-db-app.dmp", false},
+		{"postgresql://user:secret@db:5434/other", "db:5434", "/psql-db-other.dmp", false},
+		{"postgresql://user:secret@/app?host=/run/postgresql", "/run/postgresql:5432", "/psql-localhost-app.dmp", false},
+		{"postgres:///app?host=db&port=5435", "db:5435", "/psql-db-app.dmp", false},
+		{"postgresql://user:secret@/app", "", "", true},
+		{"postgresql:///app", "", "", true},
+		{"postgresql://db:port/app", "", "", true},
+		{"postgresql:///app?host=db&port=x", "", "", true},
+	}
+	for _, tt := range tests {
+		s, err := NewPostgresStep(tt.host, "app", "secret", "app")
+		if (err != nil) != tt.fail {
+			t.Errorf("%s: error %v, expected failure %v", tt.host, err, tt.fail)
+			continue
+		}
+		if tt.fail {
+			continue
+		}
+		if hostPort := s.host + ":" + strconv.Itoa(s.port); hostPort != tt.expected {
+			t.Errorf("%s: host %s, expected %s", tt.host, hostPort, tt.expected)
+		}
+		if s.name != tt.name {
+			t.Errorf("%s: name %s, expected %s", tt.host, s.name, tt.name)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+type redisStep struct {
+	running     safeBool
+	destination BackupDestination
+	host        string
+	port        int
+	user        string
+	password    string
+	tls         bool
+	cacert      string
+	cert        string
+	key         string
+	insecure    bool
+	name        string
+
+	hookable
+	summaryCollector
+	progressTracker
+	taggable
+	schedulable
+}
+
+func NewRedisStep(host string, user string, password string) (s *redisStep, err error) {
+	s = &redisStep{}
+	s.host = host
+	s.port = 6379
+	s.user = user
+	s.password = password
+
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		s.host = h
+		s.port, err = strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.New("invalid redis port: " + p)
+		}
+	}
+
+	// no sub-directory, see https://github.com/restic/restic/pull/2206 (fixed in master)
+	s.name = "/redis-" + s.host + ".rdb"
+
+	return s, nil
+}
+
+func (s *redisStep) IsRunning() bool {
+	return s.running.Get()
+}
+
+func (s *redisStep) Type() string {
+	return "redis"
+}
+
+func (s *redisStep) Description() string {
+	if s.user == "" {
+		return s.host + ":" + strconv.Itoa(s.port)
+	}
+
+	return s.user + "@" + s.host + ":" + strconv.Itoa(s.port)
+}
+
+func (s *redisStep) SetDestination(destination BackupDestination) {
+	s.destination = destination
+}
+
+func (s *redisStep) SetName(name string) {
+	s.name = name
+}
+
+func (s *redisStep) SnapshotPaths() []string {
+	return []string{s.name}
+}
+
+// SetTls enables TLS connections, certificate files are optional
+func (s *redisStep) SetTls(cacert string, cert string, key string, insecure bool) {
+	s.tls = true
+	s.cacert = cacert
+	s.cert = cert
+	s.key = key
+	s.insecure = insecure
+}
+
+func (s *redisStep) Run(m *MetricsCollection) (err error) {
+	if !s.running.SetIf(true, false) {
+		return errors.New("Backup step already running")
+	}
+	defer s.running.Set(false)
+	s.resetSummaries()
+	s.resetProgress()
+
+	// redis-cli requests a fresh RDB from the server like a replica does,
+	// so the snapshot is consistent without BGSAVE/LASTSAVE polling.
+	args := []string{"-h", s.host, "-p", strconv.Itoa(s.port), "--no-auth-warning"}
+	if s.user != "" {
+		args = append(args, "--user", s.user)
+	}
+	if s.tls {
+		args = append(args, "--tls")
+		if s.cacert != "" {
+			args = append(args, "--cacert", s.cacert)
+		}
+		if s.cert != "" {
+			args = append(args, "--cert", s.cert)
+		}
+		if s.key != "" {
+			args = append(args, "--key", s.key)
+		}
+		if s.insecure {
+			args = append(args, "--insecure")
+		}
+	}
+	args = append(args, "--rdb", "-")
+	cmdDb := exec.Command("redis-cli", args...)
+	// Password in environment only, it is visible in ps otherwise
+	cmdDb.Env = os.Environ()
+	if s.password != "" {
+		cmdDb.Env = append(cmdDb.Env, "REDISCLI_AUTH="+s.password)
+	}
+	stderrDb := bytes.NewBuffer(nil)
+	cmdDb.Stderr = stderrDb
+
+	args = []string{"backup", "--json", "--host", s.destination.hostname}
+	args = append(args, s.tagArgs()...)
+	args = append(args, s.destination.limitArgs()...)
+	args = append(args, "--stdin", "--stdin-filename", s.name)
+	cmd := exec.Command("restic", args...)
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdout = s.progressWriter(s.name, stdout)
+	cmd.Stderr = stderr
+
+	cmd.Stdin, err = cmdDb.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	// Start processes
+	if err = cmdDb.Start(); err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Wait for dump to complete
+	errDb := cmdDb.Wait()
+	// Wait for snapshot writing to complete
+	err = cmd.Wait()
+
+	if errDb != nil {
+		exiterr, ok := errDb.(*exec.ExitError)
+		if ok {
+			logger.Info("command redis-cli failed", zap.Error(errDb),
+				zap.String("stderr", stderrDb.String()), zap.Int("code", exiterr.ExitCode()),
+			)
+		} else {
+			logger.Error("command redis-cli failed", zap.Error(errDb))
+		}
+		return errDb
+	}
+
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok {
+			logger.Info("command restic backup failed",
+				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
+				zap.Int("code", exiterr.ExitCode()),
+			)
+		} else {
+			logger.Error("command restic backup failed",
+				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	// ok
+	logger.Info("ok", zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
+	s.addSummary(s.name, stdout.Bytes())
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// File extensions considered during discovery, the file header is checked as well
+var sqliteExtensions = []string{".sqlite", ".sqlite3", ".db"}
+
+type sqliteStep struct {
+	running     safeBool
+	destination BackupDestination
+	path        string
+
+	hookable
+	summaryCollector
+	progressTracker
+	taggable
+	schedulable
+}
+
+// NewSqliteStep creates a step for a single database file, or for all
+// databases found below path if it is a directory.
+func NewSqliteStep(path string) *sqliteStep {
+	s := &sqliteStep{}
+	s.path = path
+
+	return s
+}
+
+func (s *sqliteStep) IsRunning() bool {
+	return s.running.Get()
+}
+
+func (s *sqliteStep) Type() string {
+	return "sqlite"
+}
+
+func (s *sqliteStep) Description() string {
+	return s.path
+}
+
+func (s *sqliteStep) SetDestination(destination BackupDestination) {
+	s.destination = destination
+}
+
+func (s *sqliteStep) Run(m *MetricsCollection) (err error) {
+	if !s.running.SetIf(true, false) {
+		return errors.New("Backup step already running")
+	}
+	defer s.running.Set(false)
+	s.resetSummaries()
+	s.resetProgress()
+
+	files, err := s.discover()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		logger.Warn("no sqlite databases found", zap.String("path", s.path))
+		return nil
+	}
+
+	// Continue with the other databases if one fails, report the last error
+	for _, f := range files {
+		if errFile := s.backupFile(f); errFile != nil {
+			err = errFile
+		}
+	}
+
+	return err
+}
+
+// discover returns the database files to back up
+func (s *sqliteStep) discover() ([]string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{s.path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(s.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !hasSqliteExtension(path) {
+			return nil
+		}
+		if !isSqliteFile(path) {
+			logger.Debug("skipping file without sqlite header", zap.String("path", path))
+			return nil
+		}
+		files = append(files, path)
+
+		return nil
+	})
+
+	return files, err
+}
+
+// backupFile creates a consistent copy of a database using the online
+// backup API and stores the copy under the original path in restic.
+func (s *sqliteStep) backupFile(path string) error {
+	tmp, err := ioutil.TempFile("", "restic-agent-sqlite-*.db")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	// Wait up to 10s for writers to release their locks
+	cmdDb := exec.Command("sqlite3", "-bail", path, ".timeout 10000", ".backup '"+tmp.Name()+"'")
+	out, err := cmdDb.CombinedOutput()
+	if err != nil {
+		logger.Error("command sqlite3 backup failed", zap.String("path", path), zap.ByteString("output", out), zap.Error(err))
+		return err
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	args := []string{"backup", "--json", "--host", s.destination.hostname}
+	args = append(args, s.tagArgs()...)
+	args = append(args, s.destination.limitArgs()...)
+	args = append(args, "--stdin", "--stdin-filename", path)
+	cmd := exec.Command("restic", args...)
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdin = f
+	cmd.Stdout = s.progressWriter(path, stdout)
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if ok {
+			logger.Info("command restic backup failed", zap.String("path", path),
+				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
+				zap.Int("code", exiterr.ExitCode()),
+			)
+		} else {
+			logger.Error("command restic backup failed", zap.String("path", path),
+				zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	// ok
+	logger.Info("ok", zap.String("path", path), zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
+	s.addSummary(path, stdout.Bytes())
+
+	return nil
+}
+
+func hasSqliteExtension(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range sqliteExtensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSqliteFile checks for the magic header string of sqlite 3 databases
+func isSqliteFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+
+	return string(header) == "SQLite format 3\x00"
+}
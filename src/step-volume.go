@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -14,6 +18,171 @@ type volumeStep struct {
 	running     safeBool
 	destination BackupDestination
 	path        string
+	options     VolumeOptions
+	containers  *containerAction
+
+	hookable
+	summaryCollector
+	progressTracker
+	taggable
+	schedulable
+}
+
+// VolumeOptions are passed to restic backup, they are validated by Set and
+// Validate so typos are found at startup.
+type VolumeOptions struct {
+	Exclude           []string
+	IExclude          []string
+	ExcludeIfPresent  []string
+	ExcludeCaches     bool
+	ExcludeLargerThan int64 // bytes, 0 is unlimited
+	OneFileSystem     bool
+	FilesFrom         []string
+	SkipIfUnchanged   bool
+
+	// order within a run, not passed to restic, see schedulable
+	Priority int
+	After    []string
+}
+
+// ParseVolume parses a volume with options like
+// "/data/app;exclude=*.tmp;exclude-caches;exclude-larger-than=1GiB;after=postgres"
+func ParseVolume(spec string, defaults VolumeOptions) (string, VolumeOptions, error) {
+	parts := strings.Split(spec, ";")
+	o := defaults.copy()
+	for _, option := range parts[1:] {
+		if option = strings.TrimSpace(option); option == "" {
+			continue
+		}
+		kv := strings.SplitN(option, "=", 2)
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		if err := o.Set(kv[0], value); err != nil {
+			return "", o, err
+		}
+	}
+
+	return strings.TrimSpace(parts[0]), o, nil
+}
+
+// Set sets an option by its restic flag name without dashes, flags accept an empty value as true.
+// The options priority and after order the step, after may be repeated.
+func (o *VolumeOptions) Set(name string, value string) error {
+	flag := func() (bool, error) {
+		if value == "" {
+			return true, nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, errors.New("invalid value of volume option " + name + ": " + value)
+		}
+		return b, nil
+	}
+	required := func() error {
+		if value == "" {
+			return errors.New("volume option " + name + " requires a value")
+		}
+		return nil
+	}
+
+	var err error
+	switch name {
+	case "exclude":
+		if err = validatePattern(value); err == nil {
+			o.Exclude = append(o.Exclude, value)
+		}
+	case "iexclude":
+		if err = validatePattern(value); err == nil {
+			o.IExclude = append(o.IExclude, value)
+		}
+	case "exclude-if-present":
+		if err = required(); err == nil {
+			o.ExcludeIfPresent = append(o.ExcludeIfPresent, value)
+		}
+	case "exclude-caches":
+		o.ExcludeCaches, err = flag()
+	case "exclude-larger-than":
+		if err = required(); err == nil {
+			o.ExcludeLargerThan, err = parseSize(value)
+		}
+	case "one-file-system":
+		o.OneFileSystem, err = flag()
+	case "files-from":
+		if err = required(); err == nil {
+			if _, err = os.Stat(value); err != nil {
+				return errors.New("volume option files-from: " + err.Error())
+			}
+			o.FilesFrom = append(o.FilesFrom, value)
+		}
+	case "skip-if-unchanged":
+		o.SkipIfUnchanged, err = flag()
+	case "priority":
+		if o.Priority, err = strconv.Atoi(value); err != nil {
+			err = errors.New("invalid value of volume option " + name + ": " + value)
+		}
+	case "after":
+		if err = required(); err == nil {
+			o.After = append(o.After, value)
+		}
+	default:
+		err = errors.New("unknown volume option: " + name)
+	}
+
+	return err
+}
+
+// validatePattern checks the syntax of an exclude pattern
+func validatePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty exclude pattern")
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return errors.New("invalid exclude pattern: " + pattern)
+	}
+
+	return nil
+}
+
+func (o VolumeOptions) copy() VolumeOptions {
+	o.Exclude = append([]string{}, o.Exclude...)
+	o.IExclude = append([]string{}, o.IExclude...)
+	o.ExcludeIfPresent = append([]string{}, o.ExcludeIfPresent...)
+	o.FilesFrom = append([]string{}, o.FilesFrom...)
+	o.After = append([]string{}, o.After...)
+
+	return o
+}
+
+func (o VolumeOptions) args() []string {
+	var args []string
+	for _, p := range o.Exclude {
+		args = append(args, "--exclude="+p)
+	}
+	for _, p := range o.IExclude {
+		args = append(args, "--iexclude="+p)
+	}
+	for _, f := range o.ExcludeIfPresent {
+		args = append(args, "--exclude-if-present="+f)
+	}
+	if o.ExcludeCaches {
+		args = append(args, "--exclude-caches")
+	}
+	if o.ExcludeLargerThan > 0 {
+		args = append(args, "--exclude-larger-than="+strconv.FormatInt(o.ExcludeLargerThan, 10))
+	}
+	if o.OneFileSystem {
+		args = append(args, "--one-file-system")
+	}
+	for _, f := range o.FilesFrom {
+		args = append(args, "--files-from="+f)
+	}
+	if o.SkipIfUnchanged {
+		args = append(args, "--skip-if-unchanged")
+	}
+
+	return args
 }
 
 func NewVolumeStep(path string) *volumeStep {
@@ -39,27 +208,71 @@ func (s *volumeStep) SetDestination(destination BackupDestination) {
 	s.destination = destination
 }
 
+// SetContainerAction pauses or stops containers while the volume is backed up
+func (s *volumeStep) SetContainerAction(a *containerAction) {
+	s.containers = a
+}
+
+// SetOptions sets the restic options and the order of the step
+func (s *volumeStep) SetOptions(o VolumeOptions) {
+	s.options = o
+	s.SetOrder(o.Priority, o.After)
+}
+
+func (s *volumeStep) SnapshotPaths() []string {
+	return []string{s.path}
+}
+
 func (s *volumeStep) Run(m *MetricsCollection) (err error) {
 	if !s.running.SetIf(true, false) {
 		return errors.New("Backup step already running")
 	}
 	defer s.running.Set(false)
+	s.resetSummaries()
+	s.resetProgress()
 
 	args := []string{"backup", "--json", "--host", s.destination.hostname}
+	args = append(args, s.tagArgs()...)
+	args = append(args, s.destination.limitArgs()...)
 	// Mitigate https://github.com/restic/restic/issues/2345
 	// TODO: Remove when restic issue #2345 is fixed
 	args = append(args, "--cache-dir="+os.Getenv("HOME")+"/.cache/restic"+strings.ReplaceAll(s.path, "/", "-"))
 	if _, err := os.Stat(s.path + "/.resticexclude"); err == nil {
 		args = append(args, "--exclude-file="+s.path+"/.resticexclude")
 	}
+	args = append(args, s.options.args()...)
 	args = append(args, s.path)
-	cmd := exec.Command("restic", args...)
+
+	ctx := context.Background()
+	if s.containers != nil && s.containers.Timeout() > 0 {
+		// Do not keep the containers down forever
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.containers.Timeout())
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "restic", args...)
 
 	stdout := bytes.NewBuffer(nil)
 	stderr := bytes.NewBuffer(nil)
-	cmd.Stdout = stdout
+	cmd.Stdout = s.progressWriter(s.path, stdout)
 	cmd.Stderr = stderr
-	err = cmd.Run()
+	if s.containers != nil {
+		logger.Debug("container action", zap.String("path", s.path), zap.String("action", s.containers.Description()))
+		var downtime time.Duration
+		downtime, err = s.containers.Around(cmd.Run)
+		if downtime > 0 {
+			m.ContainerDowntime.Set(float64(downtime.Milliseconds()))
+			logger.Info("containers resumed", zap.String("path", s.path), zap.Duration("downtime", downtime))
+		}
+	} else {
+		err = cmd.Run()
+	}
+	if err != nil && ctx.Err() != nil {
+		logger.Error("command restic backup timed out", zap.String("path", s.path),
+			zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()),
+		)
+		return ctx.Err()
+	}
 	if err != nil {
 		exiterr, ok := err.(*exec.ExitError)
 		if ok {
@@ -78,7 +291,7 @@ func (s *volumeStep) Run(m *MetricsCollection) (err error) {
 	// ok
 	logger.Debug("backup step done", zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
 
-	// TODO: parse output, fill metrics
+	s.addSummary(s.path, stdout.Bytes())
 
 	/*
 	   restic backup --json --host restic-agent /app
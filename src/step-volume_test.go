@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	defaults := VolumeOptions{Exclude: []string{"*.log"}, Priority: 1, After: []string{"redis"}}
+	tests := []struct {
+		spec    string
+		path    string
+		options VolumeOptions
+		fail    bool
+	}{
+		{"/data/app", "/data/app", defaults, false},
+		{" /data/app ; ", "/data/app", defaults, false},
+		{"/data/app;exclude=*.tmp;exclude-caches;exclude-larger-than=1GiB", "/data/app", VolumeOptions{
+			Exclude: []string{"*.log", "*.tmp"}, ExcludeCaches: true, ExcludeLargerThan: 1 << 30,
+			Priority: 1, After: []string{"redis"},
+		}, false},
+		{"/data/app;one-file-system=false;skip-if-unchanged=true;iexclude=*.BAK", "/data/app", VolumeOptions{
+			Exclude: []string{"*.log"}, IExclude: []string{"*.BAK"}, SkipIfUnchanged: true,
+			Priority: 1, After: []string{"redis"},
+		}, false},
+		{"/data/wal;priority=-3;after=postgres;after=volume:/data/app", "/data/wal", VolumeOptions{
+			Exclude: []string{"*.log"}, Priority: -3, After: []string{"redis", "postgres", "volume:/data/app"},
+		}, false},
+		{"/data/app;exclude-if-present=.nobackup", "/data/app", VolumeOptions{
+			Exclude: []string{"*.log"}, ExcludeIfPresent: []string{".nobackup"}, Priority: 1, After: []string{"redis"},
+		}, false},
+		{"/data/app;no-such-option", "", VolumeOptions{}, true},
+		{"/data/app;exclude=[", "", VolumeOptions{}, true},
+		{"/data/app;exclude=", "", VolumeOptions{}, true},
+		{"/data/app;exclude-caches=maybe", "", VolumeOptions{}, true},
+		{"/data/app;exclude-larger-than=huge", "", VolumeOptions{}, true},
+		{"/data/app;exclude-if-present", "", VolumeOptions{}, true},
+		{"/data/app;files-from=/does/not/exist", "", VolumeOptions{}, true},
+		{"/data/app;priority=high", "", VolumeOptions{}, true},
+		{"/data/app;after", "", VolumeOptions{}, true},
+	}
+	for _, tt := range tests {
+		path, options, err := ParseVolume(tt.spec, defaults)
+		if (err != nil) != tt.fail {
+			t.Errorf("%q: error %v, expected failure %v", tt.spec, err, tt.fail)
+			continue
+		}
+		if tt.fail {
+			continue
+		}
+		if path != tt.path {
+			t.Errorf("%q: path %q, expected %q", tt.spec, path, tt.path)
+		}
+		if !reflect.DeepEqual(options.args(), tt.options.args()) || options.Priority != tt.options.Priority ||
+			!reflect.DeepEqual(options.After, tt.options.After) {
+			t.Errorf("%q: options %+v, expected %+v", tt.spec, options, tt.options)
+		}
+	}
+
+	// the defaults are copied, not extended in place
+	if len(defaults.Exclude) != 1 || len(defaults.After) != 1 {
+		t.Errorf("defaults modified: %+v", defaults)
+	}
+}
+
+func TestVolumeOptionsSet(t *testing.T) {
+	filesFrom := filepath.Join(t.TempDir(), "files")
+	if err := os.WriteFile(filesFrom, []byte("/data/app\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		args  []string
+		fail  bool
+	}{
+		{"exclude", "cache", []string{"--exclude=cache"}, false},
+		{"iexclude", "*.TMP", []string{"--iexclude=*.TMP"}, false},
+		{"exclude-if-present", ".nobackup", []string{"--exclude-if-present=.nobackup"}, false},
+		{"exclude-caches", "", []string{"--exclude-caches"}, false},
+		{"exclude-caches", "false", nil, false},
+		{"exclude-larger-than", "500M", []string{"--exclude-larger-than=524288000"}, false},
+		{"one-file-system", "1", []string{"--one-file-system"}, false},
+		{"files-from", filesFrom, []string{"--files-from=" + filesFrom}, false},
+		{"skip-if-unchanged", "", []string{"--skip-if-unchanged"}, false},
+		{"priority", "7", nil, false},
+		{"after", "postgres", nil, false},
+		{"exclude", "a[", nil, true},
+		{"one-file-system", "yes please", nil, true},
+		{"exclude-larger-than", "", nil, true},
+		{"files-from", filesFrom + ".missing", nil, true},
+		{"excludes", "cache", nil, true},
+	}
+	for _, tt := range tests {
+		var o VolumeOptions
+		err := o.Set(tt.name, tt.value)
+		if (err != nil) != tt.fail {
+			t.Errorf("%s=%s: error %v, expected failure %v", tt.name, tt.value, err, tt.fail)
+			continue
+		}
+		if args := o.args(); !tt.fail && !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s=%s: args %v, expected %v", tt.name, tt.value, args, tt.args)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Trigger sources of a run, used in the automatic "trigger:" tag
+const (
+	TriggerSchedule = "schedule"
+	TriggerStartup  = "startup"
+	TriggerHttp     = "http"
+	TriggerApi      = "api"
+)
+
+// taggable can be embedded into steps to tag their snapshots, static tags
+// are configured, run tags are set by the backup set before each run.
+type taggable struct {
+	mu      sync.Mutex
+	tags    []string
+	runTags []string
+}
+
+// TaggedStep is implemented by steps which tag their snapshots
+type TaggedStep interface {
+	Tags() []string
+	SetRunTags(tags []string)
+}
+
+func (t *taggable) SetTags(tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tags = tags
+}
+
+// Tags returns the static tags
+func (t *taggable) Tags() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string{}, t.tags...)
+}
+
+func (t *taggable) SetRunTags(tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.runTags = tags
+}
+
+// tagArgs returns the --tag arguments of restic backup
+func (t *taggable) tagArgs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var args []string
+	for _, tag := range append(append([]string{}, t.tags...), t.runTags...) {
+		args = append(args, "--tag", tag)
+	}
+
+	return args
+}
+
+// newRunId returns a sortable, unique id like "20240102-150405-1a2b3c4d"
+func newRunId() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(random)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// Dashboard, a static page using the json api
+//
+//go:embed ui
+var uiFiles embed.FS
+
+func uiFileSystem() http.FileSystem {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		// only fails for an invalid path
+		panic(err)
+	}
+
+	return http.FS(sub)
+}
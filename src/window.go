@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// timeWindow is a daily time range in local time like "22:00-06:00",
+// optionally restricted to days like "Mon-Fri 08:00-18:00". Windows
+// crossing midnight belong to the day they start.
+type timeWindow struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int
+}
+
+func parseTimeWindow(value string) (w timeWindow, err error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, errors.New("invalid time window: " + value)
+	}
+	if len(fields) == 2 {
+		if w.days, err = parseWeekdays(fields[0]); err != nil {
+			return w, err
+		}
+	} else {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+
+	times := strings.Split(fields[len(fields)-1], "-")
+	if len(times) != 2 {
+		return w, errors.New("invalid time window: " + value)
+	}
+	if w.start, err = parseClock(times[0]); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(times[1]); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, errors.New("empty time window: " + value)
+	}
+
+	return w, nil
+}
+
+// parseWeekdays parses "Mon", "Mon-Fri" or "Fri-Mon"
+func parseWeekdays(value string) (days [7]bool, err error) {
+	parts := strings.Split(strings.ToLower(value), "-")
+	from, ok := weekdays[parts[0]]
+	to := from
+	if len(parts) == 2 {
+		to, ok = weekdays[parts[1]]
+	}
+	if !ok || len(parts) > 2 {
+		return days, errors.New("invalid weekdays: " + value)
+	}
+	for d := from; ; d = (d + 1) % 7 {
+		days[d] = true
+		if d == to {
+			break
+		}
+	}
+
+	return days, nil
+}
+
+// parseClock parses "06:00" or "24:00" to minutes since midnight
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, errors.New("invalid time: " + value)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, errors.New("invalid time: " + value)
+	}
+
+	return h*60 + m, nil
+}
+
+// Contains is true if t is in the window
+func (w timeWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days[t.Weekday()] && minute >= w.start && minute < w.end
+	}
+	// crossing midnight, the part after midnight belongs to the day before
+	if minute >= w.start {
+		return w.days[t.Weekday()]
+	}
+
+	return minute < w.end && w.days[(t.Weekday()+6)%7]
+}
+
+// parseTimeWindows parses a list of windows, see parseTimeWindow
+func parseTimeWindows(values []string) ([]timeWindow, error) {
+	var windows []timeWindow
+	for _, v := range values {
+		w, err := parseTimeWindow(v)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+// windowsEnd returns the first minute after t which is in none of the
+// windows, t itself if it is not in a window.
+func windowsEnd(windows []timeWindow, t time.Time) (time.Time, error) {
+	inWindow := func(t time.Time) bool {
+		for _, w := range windows {
+			if w.Contains(t) {
+				return true
+			}
+		}
+		return false
+	}
+	if !inWindow(t) {
+		return t, nil
+	}
+
+	t = t.Truncate(time.Minute)
+	for i := 0; i < 8*24*60; i++ {
+		t = t.Add(time.Minute)
+		if !inWindow(t) {
+			return t, nil
+		}
+	}
+
+	return t, errors.New("time windows cover the whole week")
+}
+
+// limitRule is a bandwidth limit in KiB/s during a time window, 0 is unlimited
+type limitRule struct {
+	window timeWindow
+	limit  int
+}
+
+// bandwidthLimits are passed to restic as --limit-upload and
+// --limit-download, rules overwrite the default during their window.
+type bandwidthLimits struct {
+	upload        int
+	download      int
+	uploadRules   []limitRule
+	downloadRules []limitRule
+}
+
+// parseLimitRules parses rules like "00:00-06:00=0" or "Mon-Fri 08:00-18:00=512"
+func parseLimitRules(values []string) ([]limitRule, error) {
+	var rules []limitRule
+	for _, v := range values {
+		i := strings.LastIndex(v, "=")
+		if i < 0 {
+			return nil, errors.New("invalid bandwidth rule, expected <window>=<KiB/s>: " + v)
+		}
+		w, err := parseTimeWindow(v[:i])
+		if err != nil {
+			return nil, err
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(v[i+1:]))
+		if err != nil || limit < 0 {
+			return nil, errors.New("invalid bandwidth limit: " + v)
+		}
+		rules = append(rules, limitRule{window: w, limit: limit})
+	}
+
+	return rules, nil
+}
+
+func limitAt(rules []limitRule, fallback int, t time.Time) int {
+	for _, r := range rules {
+		if r.window.Contains(t) {
+			return r.limit
+		}
+	}
+
+	return fallback
+}
+
+// args returns the restic flags for the limits at t, nil limits return nothing.
+// restic cannot change the limit of a running command.
+func (l *bandwidthLimits) args(t time.Time) []string {
+	if l == nil {
+		return nil
+	}
+
+	var args []string
+	if limit := limitAt(l.uploadRules, l.upload, t); limit > 0 {
+		args = append(args, "--limit-upload="+strconv.Itoa(limit))
+	}
+	if limit := limitAt(l.downloadRules, l.download, t); limit > 0 {
+		args = append(args, "--limit-download="+strconv.Itoa(limit))
+	}
+
+	return args
+}
+
+// resticLimits are the limits of restic commands outside of steps like
+// listings, dumps and statistics, the steps use their destination
+var resticLimits *bandwidthLimits
+
+// resticCommand is exec.Command for restic with the current bandwidth limits
+func resticCommand(args ...string) *exec.Cmd {
+	return exec.Command("restic", append(args, resticLimits.args(time.Now())...)...)
+}
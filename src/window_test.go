@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at returns a time in the week of Monday, 2024-01-01
+func at(day int, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+
+	return time.Date(2024, 1, 1+day, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	for _, value := range []string{
+		"22:00-06:00", "Mon-Fri 08:00-18:00", "Sat-Sun 00:00-24:00", "fri-mon 23:30-01:00", "Wed 12:00-12:01",
+	} {
+		if _, err := parseTimeWindow(value); err != nil {
+			t.Errorf("%q: %v", value, err)
+		}
+	}
+	for _, value := range []string{
+		"", "22:00", "22:00-06:00-08:00", "Mon-Fri", "Mon Tue 08:00-09:00", "Mon-Fri-Sat 08:00-09:00",
+		"Monday 08:00-09:00", "08:00-08:00", "25:00-06:00", "08:60-09:00", "8-9", "24:01-01:00", "-1:00-02:00",
+	} {
+		if _, err := parseTimeWindow(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	tests := []struct {
+		window   string
+		day      int // 0 is Monday
+		clock    string
+		contains bool
+	}{
+		{"08:00-18:00", 0, "08:00", true},
+		{"08:00-18:00", 0, "17:59", true},
+		{"08:00-18:00", 0, "18:00", false},
+		{"08:00-18:00", 0, "07:59", false},
+		{"Mon-Fri 08:00-18:00", 4, "12:00", true},
+		{"Mon-Fri 08:00-18:00", 5, "12:00", false},
+		{"Sat-Sun 00:00-24:00", 6, "23:59", true},
+		{"Sat-Sun 00:00-24:00", 0, "00:00", false},
+		// crossing midnight, the part after midnight belongs to the day before
+		{"22:00-06:00", 0, "23:00", true},
+		{"22:00-06:00", 0, "05:59", true},
+		{"22:00-06:00", 0, "06:00", false},
+		{"22:00-06:00", 0, "21:59", false},
+		{"Fri 22:00-06:00", 4, "22:00", true},
+		{"Fri 22:00-06:00", 5, "03:00", true},
+		{"Fri 22:00-06:00", 4, "03:00", false},
+		{"Fri 22:00-06:00", 5, "22:30", false},
+		{"Sun 23:00-01:00", 7, "00:30", true},
+		{"Fri-Mon 20:00-02:00", 1, "01:00", true},
+		{"Fri-Mon 20:00-02:00", 2, "01:00", false},
+	}
+	for _, tt := range tests {
+		w, err := parseTimeWindow(tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		when := at(tt.day, tt.clock)
+		if contains := w.Contains(when); contains != tt.contains {
+			t.Errorf("%q contains %s %s: %v, expected %v", tt.window, when.Weekday(), tt.clock, contains, tt.contains)
+		}
+	}
+}
+
+func TestWindowsEnd(t *testing.T) {
+	tests := []struct {
+		windows []string
+		now     time.Time
+		end     time.Time
+		fail    bool
+	}{
+		{[]string{"08:00-18:00"}, at(0, "07:00"), at(0, "07:00"), false},
+		{[]string{"08:00-18:00"}, at(0, "09:30"), at(0, "18:00"), false},
+		{[]string{"22:00-06:00"}, at(0, "23:15"), at(1, "06:00"), false},
+		{[]string{"22:00-06:00"}, at(1, "02:00"), at(1, "06:00"), false},
+		// adjacent and overlapping windows are joined
+		{[]string{"22:00-24:00", "00:00-06:00", "05:00-07:00"}, at(0, "22:00"), at(1, "07:00"), false},
+		{[]string{"Fri 18:00-24:00", "Sat-Sun 00:00-24:00"}, at(4, "19:00"), at(7, "00:00"), false},
+		{[]string{"00:00-12:00", "12:00-24:00"}, at(0, "10:00"), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		windows, err := parseTimeWindows(tt.windows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, err := windowsEnd(windows, tt.now)
+		if (err != nil) != tt.fail {
+			t.Errorf("%v at %s: error %v, expected failure %v", tt.windows, tt.now, err, tt.fail)
+			continue
+		}
+		if !tt.fail && !end.Equal(tt.end) {
+			t.Errorf("%v at %s: end %s, expected %s", tt.windows, tt.now, end, tt.end)
+		}
+	}
+}
+
+func TestBandwidthLimits(t *testing.T) {
+	uploadRules, err := parseLimitRules([]string{"00:00-06:00=0", "Sat-Sun 00:00-24:00=1024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &bandwidthLimits{upload: 256, download: 2048, uploadRules: uploadRules}
+
+	tests := []struct {
+		t    time.Time
+		args []string
+	}{
+		{at(0, "12:00"), []string{"--limit-upload=256", "--limit-download=2048"}},
+		{at(0, "03:00"), []string{"--limit-download=2048"}},
+		{at(5, "03:00"), []string{"--limit-download=2048"}}, // first matching rule wins
+		{at(5, "12:00"), []string{"--limit-upload=1024", "--limit-download=2048"}},
+	}
+	for _, tt := range tests {
+		if args := l.args(tt.t); !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: args %v, expected %v", tt.t, args, tt.args)
+		}
+	}
+
+	var none *bandwidthLimits
+	if args := none.args(at(0, "12:00")); args != nil {
+		t.Errorf("nil limits: %v", args)
+	}
+	for _, rule := range []string{"00:00-06:00", "00:00-06:00=-1", "00:00-06:00=fast", "25:00-06:00=0"} {
+		if _, err := parseLimitRules([]string{rule}); err == nil {
+			t.Errorf("%q: expected error", rule)
+		}
+	}
+}